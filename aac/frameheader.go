@@ -0,0 +1,127 @@
+package aac
+
+type (
+	FrameHeader []byte
+
+	FrameMPEGVersion byte
+	FrameProfile     byte
+	FrameSampleRate  int
+	FrameChannels    byte
+)
+
+// FrameMPEGVersion value mappings as documented at https://wiki.multimedia.cx/index.php/ADTS
+const (
+	VersionMPEG4 FrameMPEGVersion = iota
+	VersionMPEG2
+)
+
+// FrameProfile value mappings (MPEG-4 Audio Object Type minus 1)
+const (
+	ProfileMain FrameProfile = iota
+	ProfileLC
+	ProfileSSR
+	ProfileLTP
+)
+
+// HeaderSize is the size of the ADTS header without CRC
+const HeaderSize = 7
+
+// SampleRateInvalid is a special value of FrameSampleRate indicating that
+// determination of sample rate is not possible
+const SampleRateInvalid FrameSampleRate = 0
+
+// ChannelsInBitstream means channel configuration is sent via an inband PCE
+const ChannelsInBitstream FrameChannels = 0
+
+var (
+	sampleRates = [16]FrameSampleRate{
+		96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050,
+		16000, 12000, 11025, 8000, 7350,
+		SampleRateInvalid, SampleRateInvalid, SampleRateInvalid,
+	}
+
+	// number of channels per channel configuration, 7 means 7.1
+	channelCounts = [8]int{0, 1, 2, 3, 4, 5, 6, 8}
+
+	samplesPerRawDataBlock = 1024
+)
+
+// Version returns MPEG version of the frame
+func (fh FrameHeader) Version() FrameMPEGVersion {
+	return FrameMPEGVersion((fh[1] >> 3) & 0x01)
+}
+
+// Layer returns layer bits of the frame which are always 0 for ADTS
+func (fh FrameHeader) Layer() byte {
+	return (fh[1] >> 1) & 0x03
+}
+
+// Protected returns true if the frame is CRC protected
+func (fh FrameHeader) Protected() bool {
+	return (fh[1] & 0x01) != 0x01
+}
+
+// Profile returns the AAC profile of the frame
+func (fh FrameHeader) Profile() FrameProfile {
+	return FrameProfile((fh[2] >> 6) & 0x03)
+}
+
+// SampleRate returns the sample rate of the frame. Note that for HE-AAC streams
+// it's the rate of the AAC core, the output sample rate is doubled by SBR
+func (fh FrameHeader) SampleRate() FrameSampleRate {
+	return sampleRates[(fh[2]>>2)&0x0F]
+}
+
+// ChannelConfig returns the channel configuration of the frame
+func (fh FrameHeader) ChannelConfig() FrameChannels {
+	return FrameChannels(((fh[2] & 0x01) << 2) | ((fh[3] >> 6) & 0x03))
+}
+
+// Channels returns the number of channels according to the channel configuration
+func (fh FrameHeader) Channels() int {
+	return channelCounts[fh.ChannelConfig()]
+}
+
+// FrameLength returns the length of the frame including the header
+func (fh FrameHeader) FrameLength() int {
+	return (int(fh[3]&0x03) << 11) | (int(fh[4]) << 3) | int(fh[5]>>5)
+}
+
+// BufferFullness returns the buffer fullness value, 0x7FF means VBR
+func (fh FrameHeader) BufferFullness() int {
+	return (int(fh[5]&0x1F) << 6) | int(fh[6]>>2)
+}
+
+// RawDataBlocks returns the number of AAC raw data blocks in the frame
+func (fh FrameHeader) RawDataBlocks() int {
+	return int(fh[6]&0x03) + 1
+}
+
+// HeaderLength returns the length of the header including the CRC if present
+func (fh FrameHeader) HeaderLength() int {
+	if fh.Protected() {
+		return HeaderSize + 2
+	}
+	return HeaderSize
+}
+
+// NumSamples returns number of samples per channel in frame
+func (fh FrameHeader) NumSamples() int {
+	return samplesPerRawDataBlock * fh.RawDataBlocks()
+}
+
+// FrameHeaderValid checks if data starts with a valid ADTS frame header
+func FrameHeaderValid(data []byte) bool {
+	if len(data) < HeaderSize {
+		return false
+	}
+	if data[0] == 0xFF && data[1]&0xF0 == 0xF0 {
+		hdr := FrameHeader(data[:HeaderSize])
+		if hdr.Layer() == 0 &&
+			hdr.SampleRate() != SampleRateInvalid &&
+			hdr.FrameLength() >= hdr.HeaderLength() {
+			return true
+		}
+	}
+	return false
+}
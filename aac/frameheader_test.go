@@ -0,0 +1,46 @@
+package aac
+
+import (
+	"testing"
+)
+
+// 44100Hz stereo AAC-LC frame of 371 bytes, no CRC, VBR
+var lcHeader = []byte{0xFF, 0xF1, 0x50, 0x80, 0x2E, 0x7F, 0xFC}
+
+func TestFrameHeader(t *testing.T) {
+	if !FrameHeaderValid(lcHeader) {
+		t.Fatal("valid header is considered invalid")
+	}
+	hdr := FrameHeader(lcHeader)
+	if hdr.Version() != VersionMPEG4 {
+		t.Errorf("expected MPEG-4 version, got %d", hdr.Version())
+	}
+	if hdr.Profile() != ProfileLC {
+		t.Errorf("expected LC profile, got %d", hdr.Profile())
+	}
+	if hdr.SampleRate() != 44100 {
+		t.Errorf("expected sample rate 44100, got %d", hdr.SampleRate())
+	}
+	if hdr.Channels() != 2 {
+		t.Errorf("expected 2 channels, got %d", hdr.Channels())
+	}
+	if hdr.FrameLength() != 371 {
+		t.Errorf("expected frame length 371, got %d", hdr.FrameLength())
+	}
+	if hdr.Protected() {
+		t.Error("frame is not expected to be protected")
+	}
+	if hdr.NumSamples() != 1024 {
+		t.Errorf("expected 1024 samples, got %d", hdr.NumSamples())
+	}
+}
+
+func TestFrameHeaderInvalid(t *testing.T) {
+	// MPEG-1 Layer 3 header
+	if FrameHeaderValid([]byte{0xFF, 0xFB, 0x90, 0x64, 0, 0, 0}) {
+		t.Error("mp3 header should not be considered a valid ADTS header")
+	}
+	if FrameHeaderValid(lcHeader[:4]) {
+		t.Error("short data should not be considered a valid header")
+	}
+}
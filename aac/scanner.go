@@ -0,0 +1,80 @@
+package aac
+
+import (
+	"errors"
+)
+
+type (
+	// Scanner looks for a reliable sync point in ADTS data. A header
+	// is considered a sync point only if it's followed by a number of
+	// consecutive frames with the same stream parameters
+	Scanner struct {
+		frames int
+	}
+)
+
+var (
+	// ErrNeedMoreData is returned when data ends before a sync point candidate
+	// could be confirmed. The offset of the candidate is returned along with the error
+	ErrNeedMoreData = errors.New("not enough data to confirm frame sync")
+	// ErrNoSync is returned when no sync point is found in data
+	ErrNoSync = errors.New("no valid ADTS frame found")
+)
+
+// NewScanner creates a new Scanner confirming sync by _frames_ consecutive frames
+func NewScanner(frames int) *Scanner {
+	if frames < 1 {
+		frames = 1
+	}
+	return &Scanner{frames}
+}
+
+// Sync returns the offset of the first confirmed frame in data
+func (sc *Scanner) Sync(data []byte) (int, error) {
+	for i := 0; i+HeaderSize <= len(data); i++ {
+		if !FrameHeaderValid(data[i:]) {
+			continue
+		}
+		confirmed, complete := sc.walk(data[i:])
+		if confirmed {
+			return i, nil
+		}
+		if !complete {
+			return i, ErrNeedMoreData
+		}
+	}
+	return 0, ErrNoSync
+}
+
+// walk follows the chain of frames starting at the beginning of data.
+// complete is false when data ends before the chain could be checked
+func (sc *Scanner) walk(data []byte) (confirmed bool, complete bool) {
+	first := FrameHeader(data[:HeaderSize])
+	pos := 0
+	for n := 0; n < sc.frames; n++ {
+		if pos+HeaderSize > len(data) {
+			return false, false
+		}
+		if !FrameHeaderValid(data[pos:]) {
+			return false, true
+		}
+		hdr := FrameHeader(data[pos : pos+HeaderSize])
+		if !SameStream(first, hdr) {
+			return false, true
+		}
+		size := hdr.FrameLength()
+		if pos+size > len(data) {
+			return false, false
+		}
+		pos += size
+	}
+	return true, true
+}
+
+// SameStream returns true if both headers may belong to the same stream,
+// i.e. the profile, the sample rate and the channels are the same
+func SameStream(a, b FrameHeader) bool {
+	return a.Profile() == b.Profile() &&
+		a.SampleRate() == b.SampleRate() &&
+		a.ChannelConfig() == b.ChannelConfig()
+}
@@ -0,0 +1,64 @@
+package aac
+
+import (
+	"testing"
+)
+
+func makeFrames(n int) []byte {
+	data := make([]byte, 0, n*371)
+	for i := 0; i < n; i++ {
+		frame := make([]byte, 371)
+		copy(frame, lcHeader)
+		data = append(data, frame...)
+	}
+	return data
+}
+
+func TestScannerSync(t *testing.T) {
+	sc := NewScanner(4)
+
+	// a false sync header in the garbage before the real frames
+	garbage := append([]byte{1, 2}, lcHeader...)
+	data := append(append(garbage, 3, 4), makeFrames(5)...)
+	offset, err := sc.Sync(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if offset != len(garbage)+2 {
+		t.Errorf("expected sync at offset %d, got %d", len(garbage)+2, offset)
+	}
+
+	offset, err = sc.Sync(makeFrames(5)[:371*3+10])
+	if err != ErrNeedMoreData || offset != 0 {
+		t.Errorf("expected ErrNeedMoreData at 0, got %v at %d", err, offset)
+	}
+
+	_, err = sc.Sync(make([]byte, 2000))
+	if err != ErrNoSync {
+		t.Errorf("expected ErrNoSync, got %v", err)
+	}
+}
+
+func TestScannerParametersChange(t *testing.T) {
+	sc := NewScanner(3)
+	data := makeFrames(3)
+	// second frame claims 48000Hz
+	data[371+2] = 0x4C
+	offset, err := sc.Sync(data)
+	if err == nil || offset == 0 {
+		t.Errorf("frames with changing sample rate should not sync, got %v at %d", err, offset)
+	}
+}
+
+func TestScannerResync(t *testing.T) {
+	sc := NewScanner(3)
+	// a frame cut short in the middle of the stream
+	data := append(makeFrames(2)[:371+100], makeFrames(3)...)
+	offset, err := sc.Sync(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if offset != 371+100 {
+		t.Errorf("expected sync at offset %d, got %d", 371+100, offset)
+	}
+}
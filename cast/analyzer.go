@@ -0,0 +1,207 @@
+package cast
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/viert/flamecast/aac"
+	"github.com/viert/flamecast/mpeg"
+)
+
+const (
+	analyzerWindowSize = 256
+	// a stream is VBR if more than 1/analyzerVBRShare of frames are off the nominal bitrate
+	analyzerVBRShare = 16
+)
+
+type (
+	// StreamInfo describes json representation of the stream parameters
+	// measured from the frame headers
+	StreamInfo struct {
+		Codec           string  `json:"codec"`
+		Bitrate         int     `json:"bitrate"`
+		VBR             bool    `json:"vbr"`
+		SampleRate      int     `json:"samplerate"`
+		Channels        int     `json:"channels"`
+		FramesPerSecond float64 `json:"frames_per_second"`
+		Frames          uint64  `json:"frames"`
+		SyncErrors      uint64  `json:"sync_errors"`
+	}
+
+	frameStat struct {
+		size    int
+		samples int
+		bitrate int
+	}
+
+	// streamAnalyzer walks the frames of MPEG and ADTS streams as data
+	// arrives and keeps the statistics on the recent frames
+	streamAnalyzer struct {
+		sync.Mutex
+		format     int
+		carry      []byte
+		synced     bool
+		info       StreamInfo
+		window     [analyzerWindowSize]frameStat
+		windowFill int
+		windowPos  int
+		lastHeader mpeg.FrameHeader
+	}
+)
+
+var (
+	mpegVersionNames = map[mpeg.FrameMPEGVersion]string{
+		mpeg.VersionMPEG1:   "MPEG-1",
+		mpeg.VersionMPEG2:   "MPEG-2",
+		mpeg.VersionMPEG2_5: "MPEG-2.5",
+	}
+	mpegLayerNames = map[mpeg.FrameLayer]string{
+		mpeg.Layer1: "Layer I",
+		mpeg.Layer2: "Layer II",
+		mpeg.Layer3: "Layer III",
+	}
+	aacProfileNames = map[aac.FrameProfile]string{
+		aac.ProfileMain: "Main",
+		aac.ProfileLC:   "LC",
+		aac.ProfileSSR:  "SSR",
+		aac.ProfileLTP:  "LTP",
+	}
+)
+
+func newStreamAnalyzer(format int) *streamAnalyzer {
+	if format != formatMPEG && format != formatAAC {
+		return nil
+	}
+	return &streamAnalyzer{format: format}
+}
+
+// Write parses the next portion of the stream. It returns true each time
+// a new window of frames has been analyzed
+func (sa *streamAnalyzer) Write(data []byte) bool {
+	sa.Lock()
+	defer sa.Unlock()
+
+	refreshed := false
+	sa.carry = append(sa.carry, data...)
+	pos := 0
+	for {
+		size, complete := sa.readFrame(sa.carry[pos:])
+		if !complete {
+			break
+		}
+		if size == 0 {
+			if sa.synced {
+				sa.info.SyncErrors++
+				sa.synced = false
+			}
+			pos++
+			continue
+		}
+		sa.synced = true
+		pos += size
+		sa.info.Frames++
+		if sa.info.Frames%analyzerWindowSize == 0 {
+			refreshed = true
+		}
+	}
+	sa.carry = append(sa.carry[:0], sa.carry[pos:]...)
+	return refreshed
+}
+
+// readFrame checks the frame at the beginning of data and records it.
+// complete is false if more data is needed, size is zero if there's
+// no valid frame at the beginning of data
+func (sa *streamAnalyzer) readFrame(data []byte) (size int, complete bool) {
+	frame, complete := readAudioFrame(sa.format, data)
+	if frame.size == 0 {
+		return 0, complete
+	}
+	sa.info.SampleRate = frame.sampleRate
+
+	if hdr := frame.adts; hdr != nil {
+		sa.info.Codec = fmt.Sprintf("AAC %s", aacProfileNames[hdr.Profile()])
+		sa.info.Channels = hdr.Channels()
+		sa.record(frameStat{frame.size, frame.samples, 0})
+		if hdr.BufferFullness() == 0x7FF {
+			sa.info.VBR = true
+		}
+		return frame.size, true
+	}
+
+	hdr := frame.mpeg
+	sa.info.Codec = mpegVersionNames[hdr.Version()] + " " + mpegLayerNames[hdr.Layer()]
+	if hdr.ChannelMode() == mpeg.ChannelModeSingleChannel {
+		sa.info.Channels = 1
+	} else {
+		sa.info.Channels = 2
+	}
+	sa.record(frameStat{frame.size, frame.samples, int(hdr.BitRate())})
+	sa.lastHeader = append(sa.lastHeader[:0], hdr...)
+	return frame.size, true
+}
+
+func (sa *streamAnalyzer) record(fs frameStat) {
+	sa.window[sa.windowPos] = fs
+	sa.windowPos = (sa.windowPos + 1) % analyzerWindowSize
+	if sa.windowFill < analyzerWindowSize {
+		sa.windowFill++
+	}
+}
+
+// LastHeader returns a copy of the last MPEG frame header seen
+// or nil if there was none
+func (sa *streamAnalyzer) LastHeader() mpeg.FrameHeader {
+	sa.Lock()
+	defer sa.Unlock()
+	if sa.lastHeader == nil {
+		return nil
+	}
+	return append(mpeg.FrameHeader{}, sa.lastHeader...)
+}
+
+// Info returns the current stream parameters
+func (sa *streamAnalyzer) Info() StreamInfo {
+	sa.Lock()
+	defer sa.Unlock()
+
+	info := sa.info
+	if sa.windowFill == 0 || info.SampleRate == 0 {
+		return info
+	}
+
+	bytes := 0
+	samples := 0
+	bitrates := make(map[int]int)
+	for i := 0; i < sa.windowFill; i++ {
+		fs := sa.window[i]
+		bytes += fs.size
+		samples += fs.samples
+		if fs.bitrate != 0 {
+			bitrates[fs.bitrate]++
+		}
+	}
+	if samples > 0 {
+		seconds := float64(samples) / float64(info.SampleRate)
+		info.Bitrate = int(float64(bytes)*8/seconds/1000 + 0.5)
+		info.FramesPerSecond = float64(sa.windowFill) / seconds
+	}
+
+	// a few frames of a CBR stream (e.g. the Xing/LAME tag frame or an
+	// encoder restart) may differ from the rest so the stream is VBR only
+	// if a noticeable part of the frames differ from the most common bitrate
+	nominal, nominalFrames, frames := 0, 0, 0
+	for bitrate, count := range bitrates {
+		frames += count
+		if count > nominalFrames || count == nominalFrames && bitrate > nominal {
+			nominal, nominalFrames = bitrate, count
+		}
+	}
+	if (frames-nominalFrames)*analyzerVBRShare > frames {
+		info.VBR = true
+	}
+	if !info.VBR && nominal > 0 {
+		// nominal bitrate is exact for CBR streams
+		info.Bitrate = nominal / 1000
+	}
+	return info
+}
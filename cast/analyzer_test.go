@@ -0,0 +1,122 @@
+package cast
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/viert/flamecast/mpeg"
+)
+
+// layer3Frame returns a silent MPEG Layer III frame
+func layer3Frame(t *testing.T, sampleRate int, bitrate int, channels int) []byte {
+	hdr, err := mpeg.NewLayer3Header(sampleRate, bitrate, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mpeg.SilentFrame(hdr)
+}
+
+// adtsFrame returns an unprotected AAC-LC ADTS frame of the given length
+func adtsFrame(srIndex byte, channels byte, length int, fullness int) []byte {
+	frame := make([]byte, length)
+	copy(frame, []byte{
+		0xFF,
+		0xF1,
+		0x40 | srIndex<<2 | channels>>2,
+		channels<<6 | byte(length>>11),
+		byte(length >> 3),
+		byte(length)<<5 | byte(fullness>>6),
+		byte(fullness) << 2,
+	})
+	return frame
+}
+
+func repeatFrames(frames ...[]byte) func(int) []byte {
+	return func(count int) []byte {
+		return bytes.Repeat(bytes.Join(frames, nil), count)
+	}
+}
+
+func TestStreamAnalyzer(t *testing.T) {
+	cbr128 := layer3Frame(t, 44100, 128, 2)
+	cbr64 := layer3Frame(t, 44100, 64, 2)
+	vbr256 := layer3Frame(t, 44100, 256, 2)
+
+	cases := []struct {
+		name     string
+		format   int
+		data     []byte
+		expected StreamInfo
+	}{
+		{
+			"MPEG-1 CBR",
+			formatMPEG,
+			repeatFrames(cbr128)(100),
+			StreamInfo{Codec: "MPEG-1 Layer III", Bitrate: 128, SampleRate: 44100, Channels: 2},
+		},
+		{
+			"MPEG-1 CBR with an odd first frame",
+			formatMPEG,
+			append(cbr64, repeatFrames(cbr128)(100)...),
+			StreamInfo{Codec: "MPEG-1 Layer III", Bitrate: 128, SampleRate: 44100, Channels: 2},
+		},
+		{
+			"MPEG-1 VBR",
+			formatMPEG,
+			repeatFrames(cbr128, vbr256)(50),
+			StreamInfo{Codec: "MPEG-1 Layer III", Bitrate: 192, VBR: true, SampleRate: 44100, Channels: 2},
+		},
+		{
+			"MPEG-2 mono",
+			formatMPEG,
+			append([]byte{0, 1, 2}, repeatFrames(layer3Frame(t, 22050, 32, 1))(100)...),
+			StreamInfo{Codec: "MPEG-2 Layer III", Bitrate: 32, SampleRate: 22050, Channels: 1},
+		},
+		{
+			"ADTS CBR",
+			formatAAC,
+			repeatFrames(adtsFrame(4, 2, 371, 0x100))(100),
+			StreamInfo{Codec: "AAC LC", Bitrate: 128, SampleRate: 44100, Channels: 2},
+		},
+		{
+			"ADTS VBR",
+			formatAAC,
+			repeatFrames(adtsFrame(3, 1, 200, 0x7FF), adtsFrame(3, 1, 400, 0x7FF))(50),
+			StreamInfo{Codec: "AAC LC", Bitrate: 113, VBR: true, SampleRate: 48000, Channels: 1},
+		},
+	}
+	for _, c := range cases {
+		sa := newStreamAnalyzer(c.format)
+		// the data arrives in arbitrary chunks
+		for pos := 0; pos < len(c.data); pos += 1000 {
+			end := pos + 1000
+			if end > len(c.data) {
+				end = len(c.data)
+			}
+			sa.Write(c.data[pos:end])
+		}
+		info := sa.Info()
+		info.FramesPerSecond = 0
+		info.Frames = 0
+		if info != c.expected {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, info)
+		}
+	}
+}
+
+func TestStreamAnalyzerSyncErrors(t *testing.T) {
+	frame := layer3Frame(t, 44100, 128, 2)
+	data := repeatFrames(frame)(3)
+	data = append(data, 0, 0, 0)
+	data = append(data, repeatFrames(frame)(3)...)
+
+	sa := newStreamAnalyzer(formatMPEG)
+	sa.Write(data)
+	info := sa.Info()
+	if info.Frames != 6 {
+		t.Errorf("expected 6 frames, got %d", info.Frames)
+	}
+	if info.SyncErrors != 1 {
+		t.Errorf("expected 1 sync error, got %d", info.SyncErrors)
+	}
+}
@@ -3,6 +3,7 @@ package cast
 import (
 	"encoding/json"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/viert/flamecast/configreader"
@@ -19,33 +20,53 @@ type (
 
 	// SourceDesc describes json representation of a source
 	SourceDesc struct {
-		Active      bool           `json:"active"`
-		Path        string         `json:"path"`
-		Name        string         `json:"name"`
-		Public      bool           `json:"public"`
-		Site        string         `json:"site"`
-		Genre       string         `json:"genre"`
-		Description string         `json:"description"`
-		Bitrate     int            `json:"bitrate"`
-		AudioInfo   string         `json:"audio_info"`
-		Type        string         `json:"type"`
-		Started     string         `json:"started"`
-		ContentType string         `json:"content_type"`
-		CurrentMeta icy.MetaData   `json:"current_meta"`
-		Listeners   []ListenerDesc `json:"listeners"`
+		Active      bool            `json:"active"`
+		Path        string          `json:"path"`
+		Name        string          `json:"name"`
+		Public      bool            `json:"public"`
+		Site        string          `json:"site"`
+		Genre       string          `json:"genre"`
+		Description string          `json:"description"`
+		Bitrate     int             `json:"bitrate"`
+		SampleRate  int             `json:"samplerate"`
+		Channels    int             `json:"channels"`
+		Quality     string          `json:"quality"`
+		AudioInfo   string          `json:"audio_info"`
+		Type        string          `json:"type"`
+		Started     string          `json:"started"`
+		ContentType string          `json:"content_type"`
+		StreamInfo  *StreamInfo     `json:"stream_info,omitempty"`
+		Upstream    string          `json:"upstream,omitempty"`
+		Resolved    string          `json:"resolved_upstream,omitempty"`
+		Puller      *PullerDesc     `json:"puller,omitempty"`
+		Playlist    *AutoDJDesc     `json:"playlist,omitempty"`
+		Supervisor  *SupervisorDesc `json:"supervisor,omitempty"`
+		Pacing      *PacingDesc     `json:"pacing,omitempty"`
+		Feeder      *FeederDesc     `json:"feeder,omitempty"`
+		Backup      *FeederDesc     `json:"backup_feeder,omitempty"`
+		Switches    uint64          `json:"backup_switches"`
+		Stalls      uint64          `json:"stalls"`
+		Takeovers   uint64          `json:"takeovers"`
+		CurrentMeta icy.MetaData    `json:"current_meta"`
+		Listeners   []ListenerDesc  `json:"listeners"`
 	}
 
 	// StatsData contains server stats close to what icecast stats handler provides
 	StatsData struct {
-		Admin               string       `json:"admin"`
-		Host                string       `json:"host"`
-		ListenerConnections uint64       `json:"listener_connections"`
-		FeederConnections   uint64       `json:"feeder_connections"`
-		PullerConnections   uint64       `json:"puller_connections"`
-		ListenersCount      uint         `json:"listeners_count"`
-		ServerID            string       `json:"server_id"`
-		SourcesCount        int          `json:"sources_count"`
-		Sources             []SourceDesc `json:"sources"`
+		Admin               string         `json:"admin"`
+		Host                string         `json:"host"`
+		ListenerConnections uint64         `json:"listener_connections"`
+		FeederConnections   uint64         `json:"feeder_connections"`
+		PullerConnections   uint64         `json:"puller_connections"`
+		FeederTakeovers     uint64         `json:"feeder_takeovers"`
+		SourceStalls        uint64         `json:"source_stalls"`
+		HLSPlaylistRequests uint64         `json:"hls_playlist_requests"`
+		HLSSegmentRequests  uint64         `json:"hls_segment_requests"`
+		ListenersCount      uint           `json:"listeners_count"`
+		ServerID            string         `json:"server_id"`
+		SourcesCount        int            `json:"sources_count"`
+		Sources             []SourceDesc   `json:"sources"`
+		Schedules           []ScheduleDesc `json:"schedules"`
 	}
 )
 
@@ -87,6 +108,43 @@ func adminMetadataHandler(rw http.ResponseWriter, req *http.Request) {
 	rw.Write([]byte("metadata changed"))
 }
 
+// reconnectHandler forces a PULL source to reconnect to its upstream
+func reconnectHandler(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mount := req.URL.Query().Get("mount")
+	if mount == "" {
+		http.Error(rw, "mount param is missing", http.StatusBadRequest)
+		return
+	}
+	source, found := sourcesPathMap[mount]
+	if !found {
+		http.Error(rw, "mount not found", http.StatusNotFound)
+		return
+	}
+
+	if !checkSourceAuth(source, req) {
+		http.Error(rw, "authorization failed", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case source.puller != nil:
+		logger.Noticef("SOURCE \"%s\": reconnect requested via API", mount)
+		source.puller.Reconnect()
+	case source.supervisor != nil:
+		logger.Noticef("SOURCE \"%s\": restart requested via API", mount)
+		source.supervisor.Restart()
+	default:
+		http.Error(rw, "source is not of PULL, EXEC or FIFO type", http.StatusBadRequest)
+		return
+	}
+	rw.Write([]byte("reconnect requested"))
+}
+
 func statsHandler(rw http.ResponseWriter, req *http.Request) {
 	sourcesListData := make([]SourceDesc, 0, len(sourcesPathMap))
 	for path, source := range sourcesPathMap {
@@ -99,20 +157,52 @@ func statsHandler(rw http.ResponseWriter, req *http.Request) {
 			Genre:       source.config.Stream.Genre,
 			Description: source.config.Stream.Description,
 			Bitrate:     source.config.Stream.Bitrate,
+			SampleRate:  source.config.Stream.SampleRate,
+			Channels:    source.config.Stream.Channels,
+			Quality:     source.config.Stream.Quality,
 			AudioInfo:   source.config.Stream.AudioInfo,
 			Listeners:   make([]ListenerDesc, 0, 512),
 			CurrentMeta: source.currentMeta,
 			ContentType: source.ContentType,
+			Stalls:      atomic.LoadUint64(&source.stalls),
+			Takeovers:   atomic.LoadUint64(&source.takeovers),
+			Switches:    source.feeders.Switches(),
+		}
+		sd.Feeder, sd.Backup = source.feeders.Desc()
+		if source.puller != nil {
+			sd.Puller = source.puller.Desc()
+			sd.Upstream = sd.Puller.Upstream
+			sd.Resolved = sd.Puller.Resolved
+		}
+		if pc := source.feeders.Pacer(); pc != nil {
+			sd.Pacing = pc.Desc()
+		}
+		if source.supervisor != nil {
+			sd.Supervisor = source.supervisor.Desc()
+		}
+		if source.autodj != nil {
+			sd.Playlist = source.autodj.Desc()
+		}
+		if source.analyzer != nil {
+			info := source.analyzer.Info()
+			sd.StreamInfo = &info
 		}
 		if sd.Active {
 			sd.Started = source.Started.Format(time.RFC3339)
 		} else {
 			sd.Started = ""
 		}
-		if source.config.Type == configreader.SourceTypePull {
+		switch source.config.Type {
+		case configreader.SourceTypePull:
 			sd.Type = "pull"
-		} else if source.config.Type == configreader.SourceTypePush {
+		case configreader.SourceTypePush:
 			sd.Type = "push"
+		case configreader.SourceTypePlaylist:
+			sd.Type = "playlist"
+		case configreader.SourceTypeExec:
+			sd.Type = "exec"
+		case configreader.SourceTypeFIFO:
+			sd.Type = "fifo"
 		}
 
 		source.listeners.iter(func(lr *Listener) {
@@ -127,6 +217,12 @@ func statsHandler(rw http.ResponseWriter, req *http.Request) {
 		sourcesListData = append(sourcesListData, sd)
 	}
 	stats.Sources = sourcesListData
+
+	schedulesListData := make([]ScheduleDesc, 0, len(schedulesPathMap))
+	for _, sched := range schedulesPathMap {
+		schedulesListData = append(schedulesListData, sched.Desc())
+	}
+	stats.Schedules = schedulesListData
 	response, err := json.MarshalIndent(stats, "", "  ")
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
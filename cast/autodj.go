@@ -0,0 +1,270 @@
+package cast
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viert/flamecast/icy"
+	"github.com/viert/flamecast/id3"
+	"github.com/viert/flamecast/playlist"
+)
+
+const (
+	// how far ahead of real time the file data may be written
+	autoDJLeadTime = time.Second
+	// the delay before rereading the playlist if there's nothing to play
+	autoDJRetryDelay = 10 * time.Second
+)
+
+var errNoFrames = errors.New("no MPEG frames found")
+
+type (
+	// AutoDJDesc describes json representation of a playlist source state
+	AutoDJDesc struct {
+		File     string `json:"file"`
+		Position int    `json:"position"`
+		Files    int    `json:"files"`
+	}
+
+	// autoDJ streams the MP3 files of a local playlist in real time
+	autoDJ struct {
+		sync.Mutex
+		source     *Source
+		files      []string
+		position   int
+		lastPlayed string
+		clock      time.Time
+		elapsed    time.Duration
+		written    int
+		rand       *rand.Rand
+	}
+)
+
+func newAutoDJ(source *Source) *autoDJ {
+	// the global source is not seeded, every restart would play the
+	// same shuffled order
+	return &autoDJ{source: source, position: -1, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Desc returns the playlist source state description
+func (dj *autoDJ) Desc() *AutoDJDesc {
+	dj.Lock()
+	defer dj.Unlock()
+	ad := &AutoDJDesc{Position: dj.position, Files: len(dj.files)}
+	if dj.position >= 0 && dj.position < len(dj.files) {
+		ad.File = dj.files[dj.position]
+	}
+	return ad
+}
+
+// loadFiles returns the MP3 files of a directory sorted by name,
+// the entries of an M3U playlist in order or the MP3 file itself
+func loadFiles(playlistPath string) ([]string, error) {
+	info, err := os.Stat(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(filepath.Ext(playlistPath)) == ".mp3" {
+		return []string{playlistPath}, nil
+	}
+
+	files := make([]string, 0)
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(playlistPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.ToLower(filepath.Ext(entry.Name())) == ".mp3" {
+				files = append(files, filepath.Join(playlistPath, entry.Name()))
+			}
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	data, err := ioutil.ReadFile(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := playlist.Parse(playlist.FormatM3U, data)
+	if err != nil {
+		return nil, err
+	}
+	baseDir := filepath.Dir(playlistPath)
+	for _, entry := range entries {
+		if strings.Contains(entry, "://") {
+			logger.Errorf("remote playlist entry %s is not supported in %s, skipping", entry, playlistPath)
+			continue
+		}
+		if !filepath.IsAbs(entry) {
+			entry = filepath.Join(baseDir, entry)
+		}
+		files = append(files, entry)
+	}
+	return files, nil
+}
+
+// nextRound rereads the playlist and returns the files to play in
+// the next round, shuffled if configured
+func (dj *autoDJ) nextRound() []string {
+	files, err := loadFiles(dj.source.config.PlaylistPath)
+	if err != nil {
+		logger.Errorf("SOURCE \"%s\": error loading playlist: %s", dj.source.config.Path, err.Error())
+	}
+	if dj.source.config.PlaylistShuffle {
+		dj.rand.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+		if len(files) > 1 && files[0] == dj.lastPlayed {
+			// avoiding the same track twice in a row between rounds
+			files[0], files[len(files)-1] = files[len(files)-1], files[0]
+		}
+	}
+	dj.Lock()
+	dj.files = files
+	dj.Unlock()
+	return files
+}
+
+// playRound plays the files in order and returns the number of
+// files played successfully
+func (dj *autoDJ) playRound(files []string) int {
+	played := 0
+	for idx, file := range files {
+		dj.Lock()
+		dj.position = idx
+		dj.Unlock()
+		if err := dj.play(file); err != nil {
+			logger.Errorf("SOURCE \"%s\": error playing %s: %s", dj.source.config.Path, file, err.Error())
+			continue
+		}
+		played++
+		dj.lastPlayed = file
+	}
+	return played
+}
+
+func (dj *autoDJ) run() {
+	sourcePath := dj.source.config.Path
+	dj.source.setContentType(defaultContentType)
+
+	for {
+		played := dj.playRound(dj.nextRound())
+		if played == 0 || !dj.source.config.PlaylistRepeat {
+			if dj.source.active {
+				logger.Noticef("SOURCE \"%s\": playlist is over, source is now inactive", sourcePath)
+			}
+			dj.source.active = false
+			dj.written = 0
+			if !dj.source.config.PlaylistRepeat {
+				return
+			}
+			time.Sleep(autoDJRetryDelay)
+		}
+	}
+}
+
+// trackTitle returns the stream title from the file tags or the file name
+func trackTitle(data []byte, file string) string {
+	if tag, err := id3.Parse(data); err == nil {
+		if title := tag.StreamTitle(); title != "" {
+			return title
+		}
+	}
+	if tag, err := id3.ParseV1(data); err == nil {
+		if title := tag.StreamTitle(); title != "" {
+			return title
+		}
+	}
+	name := filepath.Base(file)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// stripTags returns the audio data of the file without ID3 tags
+func stripTags(data []byte) []byte {
+	for {
+		size := id3.TagSize(data)
+		if size == 0 || size > len(data) {
+			break
+		}
+		data = data[size:]
+	}
+	if _, err := id3.ParseV1(data); err == nil {
+		data = data[:len(data)-id3.V1Size]
+	}
+	return data
+}
+
+// play writes the MPEG frames of the file to the source paced by
+// the frame durations
+func (dj *autoDJ) play(file string) error {
+	source := dj.source
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	title := trackTitle(data, file)
+	data = stripTags(data)
+
+	if time.Since(dj.clock) > dj.elapsed+autoDJLeadTime {
+		// fell behind (e.g. the source has just started), starting the clock over
+		dj.clock = time.Now()
+		dj.elapsed = 0
+	}
+
+	frames := 0
+	pos := 0
+	chunkStart := 0
+	for pos < len(data) {
+		frame, complete := readAudioFrame(formatMPEG, data[pos:])
+		if !complete {
+			break
+		}
+		if frame.size == 0 {
+			if pos > chunkStart {
+				dj.write(data[chunkStart:pos])
+			}
+			pos++
+			chunkStart = pos
+			continue
+		}
+		if frames == 0 {
+			logger.Noticef("SOURCE \"%s\": playing %s", source.config.Path, file)
+			setSourceMetadata(source, icy.MetaData{"StreamTitle": title})
+		}
+		frames++
+		pos += frame.size
+		dj.elapsed += frame.duration()
+		if pos-chunkStart >= dataBufferSize {
+			dj.write(data[chunkStart:pos])
+			chunkStart = pos
+			time.Sleep(time.Until(dj.clock.Add(dj.elapsed - autoDJLeadTime)))
+		}
+	}
+	if pos > chunkStart && frames > 0 {
+		dj.write(data[chunkStart:pos])
+	}
+	if frames == 0 {
+		return errNoFrames
+	}
+	return nil
+}
+
+func (dj *autoDJ) write(data []byte) {
+	source := dj.source
+	source.write(data)
+	if !source.active {
+		dj.written++
+		if dj.written == blocksWrittenUntilActive {
+			logger.Noticef("SOURCE \"%s\": source buffer filled, source is now active", source.config.Path)
+			source.active = true
+			source.Started = time.Now()
+		}
+	}
+}
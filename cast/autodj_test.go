@@ -0,0 +1,238 @@
+package cast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/id3"
+)
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// id3v1Tag returns an ID3v1 tag with the title and the artist
+func id3v1Tag(title string, artist string) []byte {
+	raw := make([]byte, id3.V1Size)
+	copy(raw, "TAG")
+	copy(raw[3:33], title)
+	copy(raw[33:63], artist)
+	return raw
+}
+
+// testTrack returns an MP3 file of a few silent frames tagged with the title
+func testTrack(t *testing.T, title string) []byte {
+	data := id3.Render(id3.TextFrame("TIT2", title))
+	for i := 0; i < 5; i++ {
+		data = append(data, layer3Frame(t, 44100, 128, 2)...)
+	}
+	return data
+}
+
+func newTestAutoDJ(playlistPath string, repeat bool, shuffle bool) *autoDJ {
+	newTestPushSource()
+	source := NewSource(&configreader.SourceConfig{
+		Path:            "/autodj",
+		Type:            configreader.SourceTypePlaylist,
+		PlaylistPath:    playlistPath,
+		PlaylistRepeat:  repeat,
+		PlaylistShuffle: shuffle,
+	})
+	return source.autodj
+}
+
+func TestLoadFiles(t *testing.T) {
+	newTestPushSource()
+	dir, err := ioutil.TempDir("", "autodj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"b.mp3", "a.MP3", "notes.txt", "sub/c.mp3"} {
+		writeTestFile(t, filepath.Join(dir, name), nil)
+	}
+
+	files, err := loadFiles(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{filepath.Join(dir, "a.MP3"), filepath.Join(dir, "b.mp3")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("directory: expected %v, got %v", expected, files)
+	}
+
+	single := filepath.Join(dir, "b.mp3")
+	files, err = loadFiles(single)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(files, []string{single}) {
+		t.Errorf("single file: expected %v, got %v", []string{single}, files)
+	}
+
+	m3u := filepath.Join(dir, "list.m3u")
+	writeTestFile(t, m3u, []byte("#EXTM3U\n#EXTINF:-1,Remote\nhttp://example.com/live.mp3\nsub/c.mp3\n/music/abs.mp3\nb.mp3\n"))
+	files, err = loadFiles(m3u)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = []string{filepath.Join(dir, "sub", "c.mp3"), "/music/abs.mp3", filepath.Join(dir, "b.mp3")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("m3u: expected %v, got %v", expected, files)
+	}
+
+	if _, err := loadFiles(filepath.Join(dir, "missing.m3u")); err == nil {
+		t.Error("expected an error loading a missing playlist")
+	}
+}
+
+func TestStripTags(t *testing.T) {
+	audio := []byte{0xFF, 0xFB, 0x90, 0x64, 1, 2, 3}
+	v2 := id3.Render(id3.TextFrame("TIT2", "Title"))
+	v1 := id3v1Tag("Title", "Artist")
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected []byte
+	}{
+		{"untagged", audio, audio},
+		{"v2", append(append([]byte{}, v2...), audio...), audio},
+		{"stacked v2", append(append(append([]byte{}, v2...), v2...), audio...), audio},
+		{"v1", append(append([]byte{}, audio...), v1...), audio},
+		{"v2 and v1", append(append(append([]byte{}, v2...), audio...), v1...), audio},
+		{"truncated v2", v2[:len(v2)-1], v2[:len(v2)-1]},
+	}
+	for _, c := range cases {
+		if data := stripTags(c.data); !bytes.Equal(data, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, data)
+		}
+	}
+}
+
+func TestTrackTitle(t *testing.T) {
+	audio := []byte{0xFF, 0xFB, 0x90, 0x64}
+	v2 := id3.Render(id3.TextFrame("TPE1", "Artist"), id3.TextFrame("TIT2", "Title"))
+	v2NoTitle := id3.Render(id3.TextFrame("TALB", "Album"))
+	v1 := id3v1Tag("Old Title", "Old Artist")
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"v2", append(append([]byte{}, v2...), audio...), "Artist - Title"},
+		{"v2 over v1", append(append(append([]byte{}, v2...), audio...), v1...), "Artist - Title"},
+		{"v1", append(append([]byte{}, audio...), v1...), "Old Artist - Old Title"},
+		{"v2 without title", append(append(append([]byte{}, v2NoTitle...), audio...), v1...), "Old Artist - Old Title"},
+		{"untagged", audio, "Some Track"},
+	}
+	for _, c := range cases {
+		if title := trackTitle(c.data, "/music/Some Track.mp3"); title != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, title)
+		}
+	}
+}
+
+func TestAutoDJShuffle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autodj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	all := make([]string, 0)
+	for _, name := range []string{"a.mp3", "b.mp3", "c.mp3", "d.mp3"} {
+		path := filepath.Join(dir, name)
+		writeTestFile(t, path, nil)
+		all = append(all, path)
+	}
+	dj := newTestAutoDJ(dir, true, true)
+	dj.rand = rand.New(rand.NewSource(1))
+
+	for round := 0; round < 100; round++ {
+		files := dj.nextRound()
+		sorted := append([]string{}, files...)
+		sort.Strings(sorted)
+		if !reflect.DeepEqual(sorted, all) {
+			t.Fatalf("round %d: expected a permutation of %v, got %v", round, all, files)
+		}
+		if files[0] == dj.lastPlayed {
+			t.Fatalf("round %d: %s is played twice in a row", round, files[0])
+		}
+		dj.lastPlayed = files[len(files)-1]
+	}
+}
+
+func TestAutoDJRepeat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autodj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, filepath.Join(dir, "a.mp3"), testTrack(t, "A"))
+	writeTestFile(t, filepath.Join(dir, "b.mp3"), testTrack(t, "B"))
+	dj := newTestAutoDJ(dir, true, false)
+
+	files := dj.nextRound()
+	if played := dj.playRound(files); played != 2 {
+		t.Fatalf("expected 2 files played, got %d", played)
+	}
+	if dj.source.currentMeta["StreamTitle"] != "B" {
+		t.Errorf("expected the last track title, got %v", dj.source.currentMeta)
+	}
+
+	// the playlist is reread every round
+	writeTestFile(t, filepath.Join(dir, "c.mp3"), testTrack(t, "C"))
+	files = dj.nextRound()
+	expected := []string{filepath.Join(dir, "a.mp3"), filepath.Join(dir, "b.mp3"), filepath.Join(dir, "c.mp3")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %v, got %v", expected, files)
+	}
+	if played := dj.playRound(files); played != 3 {
+		t.Fatalf("expected 3 files played, got %d", played)
+	}
+	if desc := dj.Desc(); desc.Position != 2 || desc.Files != 3 || desc.File != expected[2] {
+		t.Errorf("unexpected state %+v", desc)
+	}
+}
+
+func TestAutoDJRunOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autodj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, filepath.Join(dir, "a.mp3"), testTrack(t, "A"))
+	writeTestFile(t, filepath.Join(dir, "b.mp3"), []byte("not an mp3 file"))
+	writeTestFile(t, filepath.Join(dir, "c.mp3"), testTrack(t, "C"))
+	dj := newTestAutoDJ(dir, false, false)
+	dj.source.active = true
+
+	// without repeat the source stops after a single round
+	dj.run()
+	if dj.lastPlayed != filepath.Join(dir, "c.mp3") {
+		t.Errorf("expected c.mp3 played last, got %s", dj.lastPlayed)
+	}
+	if dj.source.currentMeta["StreamTitle"] != "C" {
+		t.Errorf("expected the last track title, got %v", dj.source.currentMeta)
+	}
+	if dj.source.active {
+		t.Error("expected the source to become inactive once the playlist is over")
+	}
+}
@@ -0,0 +1,360 @@
+package cast
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+)
+
+// Feeder roles
+const (
+	FeederRolePrimary = "primary"
+	FeederRoleBackup  = "backup"
+)
+
+var errSourceBusy = errors.New("source is already streaming")
+
+type (
+	// FeederDesc describes json representation of a feeder connected to a PUSH source
+	FeederDesc struct {
+		Role       string     `json:"role"`
+		RemoteAddr string     `json:"remote_addr"`
+		Connected  time.Time  `json:"connected_at"`
+		Override   bool       `json:"override"`
+		Streaming  bool       `json:"streaming"`
+		Healthy    bool       `json:"healthy"`
+		LastData   *time.Time `json:"last_data_at,omitempty"`
+		Bytes      uint64     `json:"bytes"`
+		Stalls     uint64     `json:"stalls"`
+	}
+
+	// feeder is a source client connection streaming into a PUSH source
+	feeder struct {
+		remoteAddr   string
+		connected    time.Time
+		override     bool
+		backup       bool
+		lastData     time.Time
+		healthySince time.Time
+		bytes        uint64
+		stalls       uint64
+		pacer        *pacer
+		evicted      chan struct{}
+		done         chan struct{}
+		stallTimer   *time.Timer
+	}
+
+	// feederSet holds the primary and the hot-standby backup feeders of
+	// a PUSH source. Only one of them writes to the source buffer. A feeder
+	// sending no data for stallTime is considered stalled and the source
+	// switches to the other one
+	feederSet struct {
+		sync.Mutex
+		primary   *feeder
+		backup    *feeder
+		onBackup  bool
+		switches  uint64
+		stallTime time.Duration
+		// the pacer of EXEC and FIFO sources having no feeders
+		pacer *pacer
+	}
+)
+
+func newFeederSet(stallTime time.Duration) *feederSet {
+	if stallTime == 0 {
+		stallTime = configreader.DefaultBackupStallTime * time.Second
+	}
+	return &feederSet{stallTime: stallTime}
+}
+
+func newFeeder(remoteAddr string, override bool, backup bool) *feeder {
+	return &feeder{
+		remoteAddr: remoteAddr,
+		connected:  time.Now(),
+		override:   override,
+		backup:     backup,
+		evicted:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (f *feeder) role() string {
+	if f.backup {
+		return FeederRoleBackup
+	}
+	return FeederRolePrimary
+}
+
+// lastSeen returns the time the feeder has sent data last. A feeder
+// which has just connected is given a chance
+func (f *feeder) lastSeen() time.Time {
+	if f.lastData.IsZero() {
+		return f.connected
+	}
+	return f.lastData
+}
+
+// onEvict calls stop to break the feeder connection once the feeder
+// gets evicted by another one
+func (f *feeder) onEvict(stop func()) {
+	go func() {
+		select {
+		case <-f.evicted:
+			stop()
+		case <-f.done:
+		}
+	}()
+}
+
+// isEvicted returns true if the feeder has been replaced by another one
+func (f *feeder) isEvicted() bool {
+	select {
+	case <-f.evicted:
+		return true
+	default:
+		return false
+	}
+}
+
+// desc returns the feeder description, the set lock must be held
+func (fs *feederSet) desc(f *feeder) *FeederDesc {
+	if f == nil {
+		return nil
+	}
+	fd := &FeederDesc{
+		Role:       f.role(),
+		RemoteAddr: f.remoteAddr,
+		Connected:  f.connected,
+		Override:   f.override,
+		Streaming:  f.backup == fs.onBackup,
+		Healthy:    !fs.stalled(f, time.Now()),
+		Bytes:      f.bytes,
+		Stalls:     f.stalls,
+	}
+	if !f.lastData.IsZero() {
+		lastData := f.lastData
+		fd.LastData = &lastData
+	}
+	return fd
+}
+
+// Desc returns the descriptions of the primary and the backup feeders
+func (fs *feederSet) Desc() (*FeederDesc, *FeederDesc) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.desc(fs.primary), fs.desc(fs.backup)
+}
+
+// Switches returns how many times the source has switched between
+// the primary and the backup feeders
+func (fs *feederSet) Switches() uint64 {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.switches
+}
+
+// stalled returns true if the feeder has sent nothing for the stall time,
+// the set lock must be held
+func (fs *feederSet) stalled(f *feeder, now time.Time) bool {
+	return now.Sub(f.lastSeen()) > fs.stallTime
+}
+
+// streaming returns true if the feeder data goes to the source buffer
+func (fs *feederSet) streaming(f *feeder) bool {
+	fs.Lock()
+	defer fs.Unlock()
+	return f.backup == fs.onBackup
+}
+
+// setPacer publishes the pacing state of the feeder, f is nil for the
+// sources having no feeders
+func (fs *feederSet) setPacer(f *feeder, pc *pacer) {
+	fs.Lock()
+	defer fs.Unlock()
+	if f == nil {
+		fs.pacer = pc
+		return
+	}
+	f.pacer = pc
+}
+
+// Pacer returns the pacer of the streaming feeder, nil if the source
+// is not paced
+func (fs *feederSet) Pacer() *pacer {
+	fs.Lock()
+	defer fs.Unlock()
+	f := fs.primary
+	if fs.onBackup {
+		f = fs.backup
+	}
+	if f != nil {
+		return f.pacer
+	}
+	return fs.pacer
+}
+
+// standby returns true if both the primary and the backup feeders are
+// connected so losing one of them doesn't stop the source
+func (fs *feederSet) standby() bool {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.primary != nil && fs.backup != nil
+}
+
+// canTakeOver returns true if the newly connected feeder may replace the
+// current one. Override feeders always win, otherwise the newest feeder
+// wins if the source is configured so
+func (s *Source) canTakeOver(current *feeder, f *feeder) bool {
+	if f.override {
+		return true
+	}
+	return s.config.Takeover && !current.override
+}
+
+// attachFeeder makes the feeder the one streaming into the source (or
+// the hot-standby one). The current feeder of the same role, if any, is
+// disconnected first (after writing its last complete frame) so that the
+// stream switches at a frame boundary and the listeners stay connected
+func (s *Source) attachFeeder(f *feeder) error {
+	fs := s.feeders
+	fs.Lock()
+	slot := &fs.primary
+	if f.backup {
+		slot = &fs.backup
+	}
+	current := *slot
+	if current != nil && !s.canTakeOver(current, f) {
+		fs.Unlock()
+		return errSourceBusy
+	}
+	*slot = f
+	if f.backup && fs.primary == nil {
+		fs.onBackup = true
+	}
+	if !f.backup && s.config.BackupAuthToken != "" {
+		// the primary feeder stall is noticed even while the backup
+		// feeder is not writing
+		f.stallTimer = time.AfterFunc(fs.stallTime, func() { s.checkStall(f) })
+	}
+	fs.Unlock()
+
+	if current != nil {
+		logger.Noticef("SOURCE \"%s\": %s feeder %s takes over from feeder %s", s.config.Path, f.role(), f.remoteAddr, current.remoteAddr)
+		stats.FeederTakeovers++
+		atomic.AddUint64(&s.takeovers, 1)
+		close(current.evicted)
+		<-current.done
+	}
+	return nil
+}
+
+// detachFeeder is called when the feeder connection is over. The source
+// switches to the other feeder if there's one, otherwise it gets inactive
+// unless the feeder has been replaced by another one
+func (s *Source) detachFeeder(f *feeder) {
+	fs := s.feeders
+	fs.Lock()
+	defer fs.Unlock()
+	close(f.done)
+	if f.stallTimer != nil {
+		f.stallTimer.Stop()
+	}
+
+	if f.backup {
+		if fs.backup != f {
+			return
+		}
+		fs.backup = nil
+		if !fs.onBackup {
+			return
+		}
+		fs.onBackup = false
+		if fs.primary == nil {
+			s.active = false
+			return
+		}
+		logger.Noticef("SOURCE \"%s\": backup feeder is gone, switching to primary feeder %s", s.config.Path, fs.primary.remoteAddr)
+		fs.switches++
+		return
+	}
+
+	if fs.primary != f {
+		return
+	}
+	fs.primary = nil
+	if fs.backup == nil {
+		s.active = false
+		return
+	}
+	if !fs.onBackup {
+		logger.Noticef("SOURCE \"%s\": primary feeder is gone, switching to backup feeder %s", s.config.Path, fs.backup.remoteAddr)
+		fs.onBackup = true
+		fs.switches++
+	}
+}
+
+// writeFeeder writes the complete frames received by the feeder to the source
+// if the feeder is the one streaming. The source switches to the backup
+// feeder as soon as the primary one stalls and switches back once the
+// primary has been healthy for the configured period. It returns false
+// if the data has been discarded
+func (s *Source) writeFeeder(f *feeder, frames []byte) bool {
+	fs := s.feeders
+	fs.Lock()
+	defer fs.Unlock()
+
+	now := time.Now()
+	if fs.stalled(f, now) || f.healthySince.IsZero() {
+		if !f.lastData.IsZero() {
+			f.stalls++
+		}
+		f.healthySince = now
+	}
+	f.lastData = now
+	f.bytes += uint64(len(frames))
+	if f.stallTimer != nil {
+		f.stallTimer.Reset(fs.stallTime)
+	}
+
+	if f.backup {
+		if !fs.onBackup && (fs.primary == nil || fs.stalled(fs.primary, now)) {
+			s.switchToBackup()
+		}
+	} else if fs.onBackup && now.Sub(f.healthySince) >= s.config.BackupSwitchBack {
+		logger.Noticef("SOURCE \"%s\": primary feeder %s has been healthy for %s, switching back", s.config.Path, f.remoteAddr, s.config.BackupSwitchBack)
+		fs.onBackup = false
+		fs.switches++
+	}
+
+	if f.backup != fs.onBackup {
+		return false
+	}
+	s.write(frames)
+	return true
+}
+
+// switchToBackup makes the backup feeder the one streaming, the set lock
+// must be held
+func (s *Source) switchToBackup() {
+	fs := s.feeders
+	logger.Noticef("SOURCE \"%s\": primary feeder has stalled, switching to backup feeder %s", s.config.Path, fs.backup.remoteAddr)
+	fs.onBackup = true
+	fs.switches++
+}
+
+// checkStall is run by the primary feeder stall timer. The source switches
+// to the backup feeder if it's healthy, otherwise it's up to the backup
+// feeder to switch once it sends data
+func (s *Source) checkStall(f *feeder) {
+	fs := s.feeders
+	fs.Lock()
+	defer fs.Unlock()
+	now := time.Now()
+	if fs.primary != f || fs.onBackup || fs.backup == nil || !fs.stalled(f, now) || fs.stalled(fs.backup, now) {
+		return
+	}
+	s.switchToBackup()
+}
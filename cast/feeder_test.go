@@ -0,0 +1,194 @@
+package cast
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+	"github.com/viert/flamecast/aac"
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/mpeg"
+)
+
+const testStallTime = 50 * time.Millisecond
+
+var testFrames = []byte{0xFF, 0xFB, 0x90, 0x64, 0, 0, 0, 0}
+
+func newTestPushSource() *Source {
+	if logger == nil {
+		logger = logging.MustGetLogger(LoggerModule)
+		logging.SetLevel(logging.CRITICAL, LoggerModule)
+	}
+	if config == nil {
+		config = &configreader.Config{HLSSegmentTime: 4 * time.Second, HLSSegments: 6, SyncFrames: 4}
+	}
+	if frameScanner == nil {
+		frameScanner = mpeg.NewScanner(config.SyncFrames, false)
+		adtsScanner = aac.NewScanner(config.SyncFrames)
+	}
+	return NewSource(&configreader.SourceConfig{
+		Path:             "/test",
+		Type:             configreader.SourceTypePush,
+		BackupAuthToken:  "backup",
+		BackupSwitchBack: 2 * testStallTime,
+		BackupStallTime:  testStallTime,
+	})
+}
+
+// attachTestFeeder attaches the feeder detaching it once it's evicted
+// like the feeder connection handlers do
+func attachTestFeeder(t *testing.T, s *Source, f *feeder) {
+	if err := s.attachFeeder(f); err != nil {
+		t.Fatalf("unexpected error attaching %s feeder: %s", f.role(), err)
+	}
+	f.onEvict(func() { s.detachFeeder(f) })
+}
+
+// setLastData pretends the feeder has sent data at the given time
+func setLastData(s *Source, f *feeder, t time.Time) {
+	s.feeders.Lock()
+	f.lastData = t
+	s.feeders.Unlock()
+}
+
+func TestAttachFeederBusy(t *testing.T) {
+	s := newTestPushSource()
+	attachTestFeeder(t, s, newFeeder("primary", false, false))
+	if err := s.attachFeeder(newFeeder("second", false, false)); err != errSourceBusy {
+		t.Errorf("expected errSourceBusy, got %v", err)
+	}
+	if s.feeders.primary.remoteAddr != "primary" {
+		t.Errorf("primary feeder has been replaced by %s", s.feeders.primary.remoteAddr)
+	}
+}
+
+func TestAttachFeederOverride(t *testing.T) {
+	s := newTestPushSource()
+	current := newFeeder("primary", false, false)
+	attachTestFeeder(t, s, current)
+	attachTestFeeder(t, s, newFeeder("override", true, false))
+	if !current.isEvicted() {
+		t.Error("current feeder is expected to be evicted")
+	}
+	if s.feeders.primary.remoteAddr != "override" {
+		t.Errorf("expected override feeder to be primary, got %s", s.feeders.primary.remoteAddr)
+	}
+	if takeovers := atomic.LoadUint64(&s.takeovers); takeovers != 1 {
+		t.Errorf("expected 1 takeover, got %d", takeovers)
+	}
+	// the override feeder can't be taken over by a regular one
+	s.config.Takeover = true
+	if err := s.attachFeeder(newFeeder("third", false, false)); err != errSourceBusy {
+		t.Errorf("expected errSourceBusy, got %v", err)
+	}
+}
+
+func TestWriteFeederStandby(t *testing.T) {
+	s := newTestPushSource()
+	primary := newFeeder("primary", false, false)
+	backup := newFeeder("backup", false, true)
+	attachTestFeeder(t, s, primary)
+	attachTestFeeder(t, s, backup)
+
+	if !s.writeFeeder(primary, testFrames) {
+		t.Error("primary feeder data is expected to be written")
+	}
+	if s.writeFeeder(backup, testFrames) {
+		t.Error("backup feeder data is expected to be discarded")
+	}
+
+	// backup takes over as soon as the primary stalls
+	setLastData(s, primary, time.Now().Add(-2*testStallTime))
+	if !s.writeFeeder(backup, testFrames) {
+		t.Error("backup feeder data is expected to be written after the primary stall")
+	}
+	if s.writeFeeder(primary, testFrames) {
+		t.Error("primary feeder data is expected to be discarded until it's healthy again")
+	}
+	if s.feeders.Switches() != 1 {
+		t.Errorf("expected 1 switch, got %d", s.feeders.Switches())
+	}
+
+	// switching back after the primary has been healthy for switch_back
+	for start := time.Now(); time.Since(start) < 3*testStallTime; {
+		s.writeFeeder(primary, testFrames)
+		s.writeFeeder(backup, testFrames)
+		time.Sleep(testStallTime / 5)
+	}
+	if !s.writeFeeder(primary, testFrames) {
+		t.Error("primary feeder data is expected to be written after switching back")
+	}
+	if s.feeders.Switches() != 2 {
+		t.Errorf("expected 2 switches, got %d", s.feeders.Switches())
+	}
+	if primary.stalls != 1 {
+		t.Errorf("expected 1 primary stall, got %d", primary.stalls)
+	}
+}
+
+func TestPrimaryStallTimer(t *testing.T) {
+	s := newTestPushSource()
+	primary := newFeeder("primary", false, false)
+	backup := newFeeder("backup", false, true)
+	attachTestFeeder(t, s, primary)
+	attachTestFeeder(t, s, backup)
+
+	// the backup is healthy but doesn't write, the primary sends nothing
+	for start := time.Now(); time.Since(start) < 3*testStallTime; {
+		setLastData(s, backup, time.Now())
+		time.Sleep(testStallTime / 5)
+	}
+	if !s.feeders.streaming(backup) {
+		t.Error("stall timer is expected to switch to the backup feeder")
+	}
+}
+
+func TestDetachFeederPromotion(t *testing.T) {
+	s := newTestPushSource()
+	primary := newFeeder("primary", false, false)
+	backup := newFeeder("backup", false, true)
+	attachTestFeeder(t, s, primary)
+	attachTestFeeder(t, s, backup)
+	s.active = true
+
+	// the backup is promoted when the primary disconnects
+	s.detachFeeder(primary)
+	if !s.feeders.streaming(backup) || !s.active {
+		t.Error("backup feeder is expected to stream after the primary is gone")
+	}
+	if s.feeders.standby() {
+		t.Error("no standby is expected with a single feeder")
+	}
+
+	// a new primary feeder doesn't stream until it's healthy
+	primary = newFeeder("primary2", false, false)
+	attachTestFeeder(t, s, primary)
+	if s.writeFeeder(primary, testFrames) {
+		t.Error("new primary feeder data is expected to be discarded at first")
+	}
+
+	// the primary is promoted at once when the backup disconnects
+	s.detachFeeder(backup)
+	if !s.feeders.streaming(primary) || !s.active {
+		t.Error("primary feeder is expected to stream after the backup is gone")
+	}
+	if s.feeders.Switches() != 2 {
+		t.Errorf("expected 2 switches, got %d", s.feeders.Switches())
+	}
+
+	// the source stops with the last feeder
+	s.detachFeeder(primary)
+	if s.active {
+		t.Error("source is expected to be inactive with no feeders")
+	}
+}
+
+func TestBackupFeederAlone(t *testing.T) {
+	s := newTestPushSource()
+	backup := newFeeder("backup", false, true)
+	attachTestFeeder(t, s, backup)
+	if !s.writeFeeder(backup, testFrames) {
+		t.Error("backup feeder data is expected to be written with no primary feeder")
+	}
+}
@@ -0,0 +1,306 @@
+package cast
+
+import (
+	"errors"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/viert/endless"
+	"github.com/viert/flamecast/aac"
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/mpeg"
+	"github.com/viert/flamecast/ogg"
+)
+
+// Stream format valid values
+const (
+	formatMPEG = iota
+	formatOgg
+	formatAAC
+)
+
+const (
+	defaultContentType = "audio/mpeg"
+	maxSyncBufferSize  = 64 * 1024
+	// maximum size of a frame (MPEG-1 Layer 1 free format with padding is well below)
+	maxFrameSize = 8192
+)
+
+var (
+	// errSyncNeedMore means the data is not long enough to confirm a sync point
+	errSyncNeedMore = errors.New("more data needed to confirm sync")
+	frameScanner    = mpeg.NewScanner(configreader.DefaultSyncFrames, false)
+	adtsScanner     = aac.NewScanner(configreader.DefaultSyncFrames)
+)
+
+func formatFromContentType(contentType string) int {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+	switch mediaType {
+	case "application/ogg", "audio/ogg", "audio/vorbis", "audio/opus", "audio/x-flac+ogg", "video/ogg":
+		return formatOgg
+	case "audio/aac", "audio/aacp", "audio/x-aac", "audio/x-aacp":
+		return formatAAC
+	default:
+		return formatMPEG
+	}
+}
+
+// setContentType sets up the source stream format for a newly connected feeder.
+// The audio parameters left by the previous feeder are reset to the configured
+// ones so it must be called before reading the feeder headers
+func (s *Source) setContentType(contentType string) {
+	if contentType == "" {
+		contentType = s.config.ContentType
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	s.ContentType = contentType
+	s.config.Stream.ResetAudioInfo(&s.config.ConfiguredStream)
+	s.format = formatFromContentType(contentType)
+	s.feedStart = s.Buffer.End()
+	if s.format == formatOgg {
+		s.ogg = ogg.NewHeaderCache(s.Buffer.End())
+	} else {
+		s.ogg = nil
+	}
+	s.analyzer = newStreamAnalyzer(s.format)
+	s.segmenter.reset(s.format, contentType)
+}
+
+// write puts the feeder data into the source buffer
+func (s *Source) write(data []byte) {
+	s.Buffer.Write(data)
+	if s.ogg != nil {
+		s.ogg.Write(data)
+	}
+	if s.analyzer != nil && s.analyzer.Write(data) {
+		s.applyStreamInfo()
+	}
+	s.segmenter.Write(data, s.currentMeta["StreamTitle"])
+}
+
+// applyStreamInfo overrides the configured (or feeder provided) audio
+// parameters with the ones measured by the stream analyzer
+func (s *Source) applyStreamInfo() {
+	info := s.analyzer.Info()
+	stream := &s.config.Stream
+	if info.Bitrate > 0 {
+		stream.Bitrate = info.Bitrate
+	}
+	if info.SampleRate > 0 {
+		stream.SampleRate = info.SampleRate
+	}
+	if info.Channels > 0 {
+		stream.Channels = info.Channels
+	}
+	audioInfo := stream.RenderAudioInfo()
+	if audioInfo != stream.AudioInfo {
+		stream.AudioInfo = audioInfo
+		logger.Noticef("SOURCE \"%s\": stream analyzed as %s, %s", s.config.Path, info.Codec, audioInfo)
+	}
+}
+
+// newReader creates a buffer reader for a listener joining the source and
+// returns the data to be sent to the listener before the buffer data
+func (s *Source) newReader() (*endless.Reader, []byte) {
+	start := s.Buffer.MidPoint()
+	if s.feedStart > start {
+		// the data of the previous feeder is stale
+		start = s.feedStart
+	}
+	if s.format == formatOgg && s.ogg != nil {
+		headers, dataStart, ok := s.ogg.Headers()
+		if !ok {
+			// headers are still in the buffer, the data before them
+			// belongs to the previous feeder
+			return s.Buffer.NewReader(s.feedStart), nil
+		}
+		if dataStart > start {
+			start = dataStart
+		}
+		return s.Buffer.NewReader(start), headers
+	}
+	return s.Buffer.NewReader(start), nil
+}
+
+// audioFrame describes an MPEG or ADTS frame found in the stream data
+type audioFrame struct {
+	size       int
+	samples    int
+	sampleRate int
+	// the header of the frame, only one of them is set
+	mpeg mpeg.FrameHeader
+	adts aac.FrameHeader
+}
+
+// duration returns the playing time of the frame
+func (af audioFrame) duration() time.Duration {
+	return time.Duration(af.samples) * time.Second / time.Duration(af.sampleRate)
+}
+
+// readAudioFrame checks the MPEG or ADTS frame at the beginning of data.
+// complete is false if more data is needed, frame size is zero if there's
+// no valid frame at the beginning of data
+func readAudioFrame(format int, data []byte) (frame audioFrame, complete bool) {
+	switch format {
+	case formatAAC:
+		if len(data) < aac.HeaderSize {
+			return frame, false
+		}
+		if !aac.FrameHeaderValid(data) {
+			return frame, true
+		}
+		hdr := aac.FrameHeader(data[:aac.HeaderSize])
+		if len(data) < hdr.FrameLength() {
+			return frame, false
+		}
+		return audioFrame{
+			size:       hdr.FrameLength(),
+			samples:    hdr.NumSamples(),
+			sampleRate: int(hdr.SampleRate()),
+			adts:       hdr,
+		}, true
+
+	default:
+		if len(data) < 4 {
+			return frame, false
+		}
+		if !mpeg.FrameHeaderValid(data) {
+			return frame, true
+		}
+		hdr := mpeg.FrameHeader(data[:4])
+		size := hdr.FrameSize()
+		if size < 4 || size > maxFrameSize {
+			// free format bitrate is not supported
+			return frame, true
+		}
+		if len(data) < size {
+			return frame, false
+		}
+		return audioFrame{
+			size:       size,
+			samples:    hdr.NumSamples(),
+			sampleRate: int(hdr.SampleRate()),
+			mpeg:       hdr,
+		}, true
+	}
+}
+
+// frameAligner cuts the feeder data at frame (or page) boundaries so that
+// the source buffer never ends with a partial frame and the feeder may be
+// replaced seamlessly
+type frameAligner struct {
+	format   int
+	carry    []byte
+	consumed int
+}
+
+// next appends the data to the carry and returns the complete frames along
+// with their duration. The incomplete rest is kept until the next call.
+// The returned data is only valid until the next call
+func (fa *frameAligner) next(data []byte) ([]byte, time.Duration) {
+	fa.carry = append(fa.carry[:0], fa.carry[fa.consumed:]...)
+	fa.carry = append(fa.carry, data...)
+	pos := 0
+	var duration time.Duration
+	for pos < len(fa.carry) {
+		if fa.format == formatOgg {
+			page, err := ogg.ReadPage(fa.carry[pos:])
+			if ogg.IsShort(err) {
+				break
+			}
+			if err != nil {
+				// garbage between pages passes through as is
+				pos++
+				continue
+			}
+			pos += len(page)
+			continue
+		}
+		frame, complete := readAudioFrame(fa.format, fa.carry[pos:])
+		if !complete {
+			break
+		}
+		if frame.size == 0 {
+			// garbage between frames passes through as is
+			pos++
+			continue
+		}
+		pos += frame.size
+		duration += frame.duration()
+	}
+	fa.consumed = pos
+	return fa.carry[:pos], duration
+}
+
+// introCompatible returns true if the intro frames may precede the
+// source stream data
+func (s *Source) introCompatible(intro *configreader.IntroDescription) bool {
+	if s.format != formatMPEG {
+		return false
+	}
+	if s.analyzer == nil {
+		return true
+	}
+	info := s.analyzer.Info()
+	return (info.SampleRate == 0 || info.SampleRate == intro.SampleRate) &&
+		(info.Channels == 0 || info.Channels == intro.Channels)
+}
+
+// silenceFrame returns a silent frame matching the current stream along
+// with its duration or nil if silence can't be generated for the stream
+func (s *Source) silenceFrame() ([]byte, time.Duration) {
+	if s.format != formatMPEG || s.analyzer == nil {
+		return nil, 0
+	}
+	hdr := s.analyzer.LastHeader()
+	if hdr == nil {
+		return nil, 0
+	}
+	return mpeg.SilentFrame(hdr), time.Duration(hdr.NumSamples()) * time.Second / time.Duration(hdr.SampleRate())
+}
+
+// sync returns the chunk starting from the first frame (or page) boundary.
+// errSyncNeedMore is returned along with the chunk starting from the sync
+// point candidate if the chunk is too short to confirm it
+func (s *Source) sync(chunk []byte) ([]byte, error) {
+	switch s.format {
+	case formatOgg:
+		synced, ok := ogg.Sync(chunk)
+		if !ok {
+			return chunk, errors.New("no valid ogg page found")
+		}
+		return synced, nil
+	case formatAAC:
+		return adtsSync(chunk)
+	default:
+		return frameSync(chunk)
+	}
+}
+
+func frameSync(chunk []byte) ([]byte, error) {
+	offset, err := frameScanner.Sync(chunk)
+	if err == mpeg.ErrNeedMoreData {
+		return chunk[offset:], errSyncNeedMore
+	}
+	if err != nil {
+		return chunk, err
+	}
+	return chunk[offset:], nil
+}
+
+func adtsSync(chunk []byte) ([]byte, error) {
+	offset, err := adtsScanner.Sync(chunk)
+	if err == aac.ErrNeedMoreData {
+		return chunk[offset:], errSyncNeedMore
+	}
+	if err != nil {
+		return chunk, err
+	}
+	return chunk[offset:], nil
+}
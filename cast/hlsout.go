@@ -0,0 +1,271 @@
+package cast
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viert/flamecast/hls"
+	"github.com/viert/flamecast/id3"
+)
+
+const (
+	hlsPlaylistName = "index.m3u8"
+	hlsPlaylistType = "application/vnd.apple.mpegurl"
+	// the longest segment allowed if the stream has no valid frames
+	hlsMaxSegmentSize = 4 * 1024 * 1024
+)
+
+var hlsSegmentExtensions = map[int]string{
+	formatMPEG: ".mp3",
+	formatAAC:  ".aac",
+}
+
+type (
+	mediaSegment struct {
+		sequence      uint64
+		duration      time.Duration
+		discontinuity bool
+		contentType   string
+		extension     string
+		data          []byte
+	}
+
+	// segmenter cuts the source stream into packed audio HLS segments at
+	// frame boundaries keeping a sliding window of the recent segments
+	segmenter struct {
+		sync.Mutex
+		format        int
+		contentType   string
+		carry         []byte
+		frames        []byte
+		duration      time.Duration
+		title         string
+		elapsed       time.Duration
+		sequence      uint64
+		discontinuity bool
+		discSequence  uint64
+		segments      []*mediaSegment
+	}
+)
+
+func newSegmenter() *segmenter {
+	return &segmenter{format: formatMPEG, contentType: defaultContentType}
+}
+
+// reset prepares the segmenter for a new feeder. The segment being built
+// is dropped and the next one is marked as a discontinuity
+func (sg *segmenter) reset(format int, contentType string) {
+	sg.Lock()
+	defer sg.Unlock()
+	sg.format = format
+	sg.contentType = contentType
+	sg.carry = nil
+	sg.frames = nil
+	sg.duration = 0
+	sg.discontinuity = len(sg.segments) > 0
+}
+
+// supported returns true if the current stream format can be segmented
+func (sg *segmenter) supported() bool {
+	sg.Lock()
+	defer sg.Unlock()
+	_, found := hlsSegmentExtensions[sg.format]
+	return found
+}
+
+// Write walks the frames of the stream data and closes a segment as soon
+// as it reaches the configured duration. title is the stream title to be
+// carried by the segment ID3 tag
+func (sg *segmenter) Write(data []byte, title string) {
+	sg.Lock()
+	defer sg.Unlock()
+	if _, found := hlsSegmentExtensions[sg.format]; !found {
+		return
+	}
+
+	sg.carry = append(sg.carry, data...)
+	pos := 0
+	for {
+		frame, complete := readAudioFrame(sg.format, sg.carry[pos:])
+		if !complete {
+			break
+		}
+		if frame.size == 0 {
+			pos++
+			continue
+		}
+		if len(sg.frames) == 0 {
+			sg.title = title
+		}
+		sg.frames = append(sg.frames, sg.carry[pos:pos+frame.size]...)
+		sg.duration += frame.duration()
+		pos += frame.size
+		if sg.duration >= config.HLSSegmentTime || len(sg.frames) > hlsMaxSegmentSize {
+			sg.closeSegment()
+		}
+	}
+	sg.carry = append(sg.carry[:0], sg.carry[pos:]...)
+}
+
+// mpegTimestamp converts the stream time to 90 kHz MPEG clock ticks.
+// Whole seconds and the remainder are converted separately as
+// multiplying the nanoseconds overflows after a day or so
+func mpegTimestamp(d time.Duration) uint64 {
+	return uint64(d/time.Second)*90000 + uint64(d%time.Second)*90000/uint64(time.Second)
+}
+
+// closeSegment turns the collected frames into a segment prepended
+// by an ID3 tag with the segment timestamp and the stream title
+func (sg *segmenter) closeSegment() {
+	frames := []id3.Frame{id3.TimestampFrame(mpegTimestamp(sg.elapsed))}
+	if sg.title != "" {
+		frames = append(frames, id3.TextFrame("TIT2", sg.title), id3.UserTextFrame("StreamTitle", sg.title))
+	}
+	tag := id3.Render(frames...)
+
+	segment := &mediaSegment{
+		sequence:      sg.sequence,
+		duration:      sg.duration,
+		discontinuity: sg.discontinuity,
+		contentType:   sg.contentType,
+		extension:     hlsSegmentExtensions[sg.format],
+		data:          append(tag, sg.frames...),
+	}
+	sg.segments = append(sg.segments, segment)
+	if len(sg.segments) > config.HLSSegments {
+		if sg.segments[0].discontinuity {
+			sg.discSequence++
+		}
+		sg.segments = sg.segments[1:]
+	}
+
+	sg.sequence++
+	sg.elapsed += sg.duration
+	sg.discontinuity = false
+	sg.frames = nil
+	sg.duration = 0
+}
+
+// Playlist renders the media playlist of the current window. query is
+// appended to the segment URLs to pass the listener token along
+func (sg *segmenter) Playlist(query string) []byte {
+	sg.Lock()
+	defer sg.Unlock()
+	if len(sg.segments) == 0 {
+		return nil
+	}
+
+	media := &hls.MediaPlaylist{
+		TargetDuration:        config.HLSSegmentTime.Seconds(),
+		MediaSequence:         sg.segments[0].sequence,
+		DiscontinuitySequence: sg.discSequence,
+	}
+	if query != "" {
+		query = "?" + query
+	}
+	for _, segment := range sg.segments {
+		if segment.duration.Seconds() > media.TargetDuration {
+			media.TargetDuration = segment.duration.Seconds()
+		}
+		media.Segments = append(media.Segments, hls.Segment{
+			URI:           fmt.Sprintf("%d%s%s", segment.sequence, segment.extension, query),
+			Duration:      segment.duration.Seconds(),
+			Sequence:      segment.sequence,
+			Discontinuity: segment.discontinuity,
+		})
+	}
+	return media.Render()
+}
+
+// Segment returns the segment with a given sequence number if it's
+// still in the window
+func (sg *segmenter) Segment(sequence uint64) *mediaSegment {
+	sg.Lock()
+	defer sg.Unlock()
+	for _, segment := range sg.segments {
+		if segment.sequence == sequence {
+			return segment
+		}
+	}
+	return nil
+}
+
+// hlsTarget checks if the request path is an HLS playlist or segment
+// of a source and returns the source and the file name
+func hlsTarget(reqPath string) (*Source, string, bool) {
+	if _, found := sourcesPathMap[reqPath]; found {
+		return nil, "", false
+	}
+	dir, name := path.Split(reqPath)
+	source, found := sourcesPathMap[strings.TrimSuffix(dir, "/")]
+	if !found {
+		return nil, "", false
+	}
+	if name != hlsPlaylistName && path.Ext(name) != ".mp3" && path.Ext(name) != ".aac" {
+		return nil, "", false
+	}
+	return source, name, true
+}
+
+// handleHLS serves the HLS playlist and segments of the source. Unlike
+// the HTTP listeners HLS clients get no failover: every source has its own
+// segment sequence which can't be switched in the middle of a playlist, so
+// the playlist is 404 while the source is inactive (fallbacks, grace period,
+// offline placeholder and schedules are not applied) and the clients retry
+func handleHLS(rw http.ResponseWriter, req *http.Request, source *Source, name string) {
+	sourcePath := source.config.Path
+	lr := NewListener(rw, req, sourcePath)
+	if !checkListenerAuth(source, lr) {
+		http.Error(rw, "Authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	if source.config.OnDemand {
+		// HLS clients keep polling the playlist while listening
+		source.puller.Demand()
+	}
+
+	if !source.segmenter.supported() {
+		http.Error(rw, "HLS is not available for this source", http.StatusNotFound)
+		return
+	}
+
+	if name == hlsPlaylistName {
+		if !source.active {
+			http.Error(rw, "source not found", http.StatusNotFound)
+			return
+		}
+		playlist := source.segmenter.Playlist(req.URL.RawQuery)
+		if playlist == nil {
+			http.Error(rw, "source not found", http.StatusNotFound)
+			return
+		}
+		stats.HLSPlaylistRequests++
+		rw.Header().Set("Content-Type", hlsPlaylistType)
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Write(playlist)
+		return
+	}
+
+	ext := path.Ext(name)
+	sequence, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+	if err != nil {
+		http.Error(rw, "segment not found", http.StatusNotFound)
+		return
+	}
+	segment := source.segmenter.Segment(sequence)
+	if segment == nil || segment.extension != ext {
+		http.Error(rw, "segment not found", http.StatusNotFound)
+		return
+	}
+	stats.HLSSegmentRequests++
+	rw.Header().Set("Content-Type", segment.contentType)
+	rw.Header().Set("Content-Length", strconv.Itoa(len(segment.data)))
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(config.HLSSegmentTime.Seconds())*config.HLSSegments))
+	rw.Write(segment.data)
+}
@@ -0,0 +1,147 @@
+package cast
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/hls"
+)
+
+func TestMPEGTimestamp(t *testing.T) {
+	cases := []struct {
+		elapsed  time.Duration
+		expected uint64
+	}{
+		{0, 0},
+		{time.Second, 90000},
+		{4 * time.Second, 360000},
+		{1500 * time.Millisecond, 135000},
+		{26122448 * time.Nanosecond, 2351},
+		// elapsed * 90000 overflows int64 after ~28.5h
+		{30 * time.Hour, 30 * 3600 * 90000},
+		{30*time.Hour + 500*time.Millisecond, 30*3600*90000 + 45000},
+		{1000 * time.Hour, 1000 * 3600 * 90000},
+	}
+	for _, c := range cases {
+		ts := mpegTimestamp(c.elapsed)
+		if ts != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.elapsed, c.expected, ts)
+		}
+	}
+}
+
+// withHLSConfig sets up the HLS window for a test and returns the
+// function restoring the previous configuration
+func withHLSConfig(segmentTime time.Duration, segments int) func() {
+	newTestPushSource()
+	prev := config
+	config = &configreader.Config{HLSSegmentTime: segmentTime, HLSSegments: segments, SyncFrames: 4}
+	return func() { config = prev }
+}
+
+func TestSegmenter(t *testing.T) {
+	defer withHLSConfig(time.Second, 3)()
+
+	// 39 frames of 1152 samples at 44100 Hz make the first segment over 1s
+	frames := bytes.Repeat(layer3Frame(t, 44100, 128, 2), 39)
+	sg := newSegmenter()
+	for i := 0; i < 5; i++ {
+		// the frames are cut in the middle
+		sg.Write(frames[:1000], "Artist - Title")
+		sg.Write(frames[1000:], "Artist - Title")
+	}
+
+	if sg.Segment(1) != nil {
+		t.Error("segment 1 is expected to be out of the window")
+	}
+	segment := sg.Segment(4)
+	if segment == nil {
+		t.Fatal("segment 4 is expected to be in the window")
+	}
+	if segment.extension != ".mp3" || segment.contentType != defaultContentType {
+		t.Errorf("unexpected segment type %s %s", segment.extension, segment.contentType)
+	}
+	if segment.duration != 39*(1152*time.Second/44100) {
+		t.Errorf("unexpected segment duration %s", segment.duration)
+	}
+	if !bytes.HasPrefix(segment.data, []byte("ID3")) || !bytes.Contains(segment.data, []byte("Artist - Title")) {
+		t.Error("segment is expected to start with an ID3 tag carrying the title")
+	}
+	if !bytes.HasSuffix(segment.data, frames) {
+		t.Error("segment is expected to end with the stream frames")
+	}
+
+	_, media, err := hls.Parse(sg.Playlist("token=secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.MediaSequence != 2 || len(media.Segments) != 3 {
+		t.Fatalf("expected 3 segments starting with 2, got %d starting with %d", len(media.Segments), media.MediaSequence)
+	}
+	if media.Segments[0].URI != "2.mp3?token=secret" {
+		t.Errorf("unexpected segment URI %s", media.Segments[0].URI)
+	}
+
+	// a new feeder starts a discontinuity
+	sg.reset(formatMPEG, defaultContentType)
+	sg.Write(frames, "")
+	_, media, err = hls.Parse(sg.Playlist(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := media.Segments[len(media.Segments)-1]
+	if last.Sequence != 5 || !last.Discontinuity {
+		t.Errorf("expected segment 5 to be a discontinuity, got %+v", last)
+	}
+}
+
+func TestHandleHLS(t *testing.T) {
+	defer withHLSConfig(time.Second, 3)()
+
+	source := newTestPushSource()
+	fallback := NewSource(&configreader.SourceConfig{Path: "/fallback", Type: configreader.SourceTypePush})
+	source.config.FallbackPath = fallback.config.Path
+	sourcesPathMap[source.config.Path] = source
+	sourcesPathMap[fallback.config.Path] = fallback
+	defer delete(sourcesPathMap, source.config.Path)
+	defer delete(sourcesPathMap, fallback.config.Path)
+
+	frames := bytes.Repeat(layer3Frame(t, 44100, 128, 2), 39)
+	source.segmenter.Write(frames, "")
+	fallback.segmenter.Write(frames, "")
+	fallback.active = true
+
+	get := func(name string) *httptest.ResponseRecorder {
+		reqPath := source.config.Path + "/" + name
+		target, targetName, found := hlsTarget(reqPath)
+		if !found || target != source || targetName != name {
+			t.Fatalf("%s is expected to be an HLS target of the source", reqPath)
+		}
+		rw := httptest.NewRecorder()
+		handleHLS(rw, httptest.NewRequest("GET", reqPath, nil), target, targetName)
+		return rw
+	}
+
+	// HLS has no failover, the fallback playlist is not served
+	if rw := get(hlsPlaylistName); rw.Code != http.StatusNotFound {
+		t.Errorf("expected playlist status %d for an inactive source, got %d", http.StatusNotFound, rw.Code)
+	}
+
+	source.active = true
+	rw := get(hlsPlaylistName)
+	if rw.Code != http.StatusOK || rw.Header().Get("Content-Type") != hlsPlaylistType {
+		t.Errorf("expected playlist, got status %d of %s", rw.Code, rw.Header().Get("Content-Type"))
+	}
+	if rw := get("0.mp3"); rw.Code != http.StatusOK || rw.Header().Get("Content-Type") != defaultContentType {
+		t.Errorf("expected segment, got status %d of %s", rw.Code, rw.Header().Get("Content-Type"))
+	}
+	for _, name := range []string{"0.aac", "1.mp3", "x.mp3"} {
+		if rw := get(name); rw.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusNotFound, rw.Code)
+		}
+	}
+}
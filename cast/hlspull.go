@@ -0,0 +1,327 @@
+package cast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/viert/flamecast/aac"
+	"github.com/viert/flamecast/hls"
+	"github.com/viert/flamecast/icy"
+	"github.com/viert/flamecast/id3"
+	"github.com/viert/flamecast/mpegts"
+)
+
+const (
+	maxSegmentSize = 16 * 1024 * 1024
+	// number of segments from the live edge to start pulling from
+	hlsLiveEdgeSegments = 3
+	// how far ahead of real time the segment data may be written
+	hlsLeadTime = 500 * time.Millisecond
+	// number of target durations without new segments until
+	// the upstream is considered stalled
+	hlsStallTargets = 3
+)
+
+var errHLSNoAudio = errors.New("no audio stream found in HLS segment")
+
+type (
+	hlsSegment struct {
+		data     []byte
+		duration time.Duration
+		meta     []*id3.Tag
+	}
+
+	// hlsReader pulls an HLS media playlist and returns the audio elementary
+	// stream of its segments in order, paced in real time
+	hlsReader struct {
+		ctx         context.Context
+		p           *puller
+		playlistURL *url.URL
+		contentType string
+		target      time.Duration
+		nextSeq     uint64
+		endList     bool
+		lastNew     time.Time
+		queue       []hls.Segment
+
+		current   *hlsSegment
+		offset    int
+		timeline  time.Duration
+		startedAt time.Time
+	}
+)
+
+// openHLS resolves the HLS playlist (choosing an audio variant in case of
+// a master playlist), fetches the first segment to detect the audio format
+// and returns a response with the HLS reader as a body
+func (p *puller) openHLS(ctx context.Context, u *url.URL, data []byte) (*icyResponse, error) {
+	master, media, err := hls.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing HLS playlist %s: %s", redactURL(u), err.Error())
+	}
+	if master != nil {
+		variant := master.AudioURI()
+		if variant == "" {
+			return nil, fmt.Errorf("HLS playlist %s has no variant streams", redactURL(u))
+		}
+		u, err = u.Parse(variant)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HLS variant %q: %s", variant, err.Error())
+		}
+		media, u, err = p.fetchMediaPlaylist(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hr := &hlsReader{
+		ctx:         ctx,
+		p:           p,
+		playlistURL: u,
+		lastNew:     time.Now(),
+	}
+	hr.update(media, true)
+
+	// fetching the first segment to know the content type beforehand
+	if err := hr.nextSegment(); err != nil {
+		return nil, err
+	}
+	logger.Noticef("SOURCE \"%s\": HLS playlist %s resolved, target duration %s, content type %s",
+		p.source.config.Path, redactURL(u), hr.target, hr.contentType)
+
+	hdr := make(http.Header)
+	hdr.Set("Content-Type", hr.contentType)
+	return &icyResponse{
+		Proto:      "HTTP/1.1",
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     hdr,
+		Body:       hr,
+		URL:        u,
+	}, nil
+}
+
+// fetchMediaPlaylist downloads and parses a media playlist
+func (p *puller) fetchMediaPlaylist(ctx context.Context, u *url.URL) (*hls.MediaPlaylist, *url.URL, error) {
+	resp, err := p.get(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPlaylistSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading HLS playlist %s: %s", redactURL(resp.URL), err.Error())
+	}
+	_, media, err := hls.Parse(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing HLS playlist %s: %s", redactURL(resp.URL), err.Error())
+	}
+	if media == nil {
+		return nil, nil, fmt.Errorf("nested HLS master playlist %s is not supported", redactURL(resp.URL))
+	}
+	return media, resp.URL, nil
+}
+
+// update queues the segments of the playlist which haven't been pulled yet
+func (hr *hlsReader) update(media *hls.MediaPlaylist, initial bool) {
+	hr.target = time.Duration(media.TargetDuration * float64(time.Second))
+	if hr.target <= 0 {
+		hr.target = time.Second
+	}
+	hr.endList = media.EndList
+	segments := media.Segments
+	if len(segments) == 0 {
+		return
+	}
+
+	if initial {
+		start := 0
+		if !media.EndList && len(segments) > hlsLiveEdgeSegments {
+			start = len(segments) - hlsLiveEdgeSegments
+		}
+		hr.nextSeq = segments[start].Sequence
+	} else if hr.nextSeq < segments[0].Sequence {
+		logger.Errorf("SOURCE \"%s\": HLS playlist moved past segment %d, skipping to %d",
+			hr.p.source.config.Path, hr.nextSeq, segments[0].Sequence)
+		hr.nextSeq = segments[0].Sequence
+	}
+
+	for _, segment := range segments {
+		if segment.Sequence < hr.nextSeq {
+			continue
+		}
+		if segment.Discontinuity {
+			logger.Debugf("SOURCE \"%s\": HLS discontinuity at segment %d", hr.p.source.config.Path, segment.Sequence)
+		}
+		hr.queue = append(hr.queue, segment)
+		hr.nextSeq = segment.Sequence + 1
+		hr.lastNew = time.Now()
+	}
+}
+
+// sleep waits for the duration or until the puller is cancelled
+func (hr *hlsReader) sleep(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-hr.ctx.Done():
+		return hr.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// nextSegment downloads the next segment, reloading the playlist
+// until a new segment appears
+func (hr *hlsReader) nextSegment() error {
+	for len(hr.queue) == 0 {
+		if hr.endList {
+			return io.EOF
+		}
+		if time.Since(hr.lastNew) > hlsStallTargets*hr.target {
+			return fmt.Errorf("HLS playlist %s has no new segments for %s", redactURL(hr.playlistURL), time.Since(hr.lastNew))
+		}
+		if err := hr.sleep(hr.target / 2); err != nil {
+			return err
+		}
+		media, _, err := hr.p.fetchMediaPlaylist(hr.ctx, hr.playlistURL)
+		if err != nil {
+			return err
+		}
+		hr.update(media, false)
+	}
+
+	segment := hr.queue[0]
+	hr.queue = hr.queue[1:]
+	segmentURL, err := hr.playlistURL.Parse(segment.URI)
+	if err != nil {
+		return fmt.Errorf("invalid HLS segment %q: %s", segment.URI, err.Error())
+	}
+	resp, err := hr.p.get(hr.ctx, segmentURL)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSegmentSize))
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("error reading HLS segment %s: %s", redactURL(segmentURL), err.Error())
+	}
+
+	seg, contentType, err := demuxSegment(data)
+	if err != nil {
+		return fmt.Errorf("error demuxing HLS segment %s: %s", redactURL(segmentURL), err.Error())
+	}
+	if hr.contentType == "" {
+		hr.contentType = contentType
+	} else if hr.contentType != contentType {
+		return fmt.Errorf("HLS segment %s format changed from %s to %s", redactURL(segmentURL), hr.contentType, contentType)
+	}
+	seg.duration = time.Duration(segment.Duration * float64(time.Second))
+	hr.current = seg
+	hr.offset = 0
+	return nil
+}
+
+// demuxSegment extracts the audio and ID3 tags from a transport stream
+// or a packed audio segment
+func demuxSegment(data []byte) (*hlsSegment, string, error) {
+	seg := &hlsSegment{}
+	if mpegts.IsTransportStream(data) {
+		demuxed, err := mpegts.Demux(data)
+		if err != nil {
+			return nil, "", err
+		}
+		if demuxed.AudioStreamType == 0 {
+			return nil, "", errHLSNoAudio
+		}
+		for _, raw := range demuxed.Metadata {
+			if tag, err := id3.Parse(raw); err == nil {
+				seg.meta = append(seg.meta, tag)
+			}
+		}
+		seg.data = demuxed.Audio
+		if demuxed.AudioStreamType == mpegts.StreamTypeAAC {
+			return seg, "audio/aac", nil
+		}
+		return seg, defaultContentType, nil
+	}
+
+	// packed audio is prepended by an ID3 tag with a timestamp
+	for {
+		size := id3.TagSize(data)
+		if size == 0 || size > len(data) {
+			break
+		}
+		if tag, err := id3.Parse(data[:size]); err == nil {
+			seg.meta = append(seg.meta, tag)
+		}
+		data = data[size:]
+	}
+	if len(data) == 0 {
+		return nil, "", errHLSNoAudio
+	}
+	seg.data = data
+	if aac.FrameHeaderValid(data) {
+		return seg, "audio/aac", nil
+	}
+	return seg, defaultContentType, nil
+}
+
+// publishMeta sets the source metadata from the segment ID3 tags
+func (hr *hlsReader) publishMeta(seg *hlsSegment) {
+	source := hr.p.source
+	for _, tag := range seg.meta {
+		title := tag.StreamTitle()
+		if title == "" {
+			continue
+		}
+		if source.currentMeta != nil && source.currentMeta["StreamTitle"] == title {
+			continue
+		}
+		setSourceMetadata(source, icy.MetaData{"StreamTitle": title})
+	}
+}
+
+// Read returns the segments audio data spreading each segment evenly
+// over its duration
+func (hr *hlsReader) Read(buf []byte) (int, error) {
+	if hr.current == nil || hr.offset >= len(hr.current.data) {
+		if hr.current != nil {
+			hr.timeline += hr.current.duration
+			hr.current = nil
+		}
+		if err := hr.nextSegment(); err != nil {
+			return 0, err
+		}
+	}
+	seg := hr.current
+	if hr.offset == 0 {
+		if hr.startedAt.IsZero() {
+			hr.startedAt = time.Now()
+		}
+		hr.publishMeta(seg)
+	}
+
+	position := hr.timeline + time.Duration(float64(seg.duration)*float64(hr.offset)/float64(len(seg.data)))
+	if err := hr.sleep(time.Until(hr.startedAt.Add(position - hlsLeadTime))); err != nil {
+		return 0, err
+	}
+
+	n := copy(buf, seg.data[hr.offset:])
+	hr.offset += n
+	return n, nil
+}
+
+// Close implements io.Closer
+func (hr *hlsReader) Close() error {
+	return nil
+}
@@ -0,0 +1,156 @@
+package cast
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/viert/flamecast/hls"
+	"github.com/viert/flamecast/id3"
+)
+
+// testHLSUpstream serves a live media playlist at /live.m3u8 and its segments
+type testHLSUpstream struct {
+	sync.Mutex
+	playlist hls.MediaPlaylist
+	segments map[string][]byte
+}
+
+func (u *testHLSUpstream) add(uri string, data []byte, discontinuity bool) {
+	u.Lock()
+	defer u.Unlock()
+	u.playlist.Segments = append(u.playlist.Segments, hls.Segment{URI: uri, Duration: 0.1, Discontinuity: discontinuity})
+	u.segments["/"+uri] = data
+}
+
+func (u *testHLSUpstream) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	u.Lock()
+	defer u.Unlock()
+	if req.URL.Path == "/live.m3u8" {
+		rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		rw.Write(u.playlist.Render())
+		return
+	}
+	data, found := u.segments[req.URL.Path]
+	if !found {
+		http.NotFound(rw, req)
+		return
+	}
+	rw.Write(data)
+}
+
+func testMediaPlaylist(first uint64, count int, endList bool) *hls.MediaPlaylist {
+	media := &hls.MediaPlaylist{TargetDuration: 2, MediaSequence: first, EndList: endList}
+	for i := 0; i < count; i++ {
+		media.Segments = append(media.Segments, hls.Segment{Sequence: first + uint64(i), Duration: 2})
+	}
+	return media
+}
+
+func queuedSequences(hr *hlsReader) []uint64 {
+	seqs := make([]uint64, 0)
+	for _, segment := range hr.queue {
+		seqs = append(seqs, segment.Sequence)
+	}
+	// the queued segments are considered pulled
+	hr.queue = nil
+	return seqs
+}
+
+func TestHLSReaderUpdate(t *testing.T) {
+	hr := &hlsReader{p: newPuller(newTestPushSource())}
+
+	cases := []struct {
+		name     string
+		media    *hls.MediaPlaylist
+		initial  bool
+		expected []uint64
+	}{
+		// a live playlist is joined close to the live edge
+		{"join", testMediaPlaylist(10, 5, false), true, []uint64{12, 13, 14}},
+		{"reload", testMediaPlaylist(12, 5, false), false, []uint64{15, 16}},
+		{"no new segments", testMediaPlaylist(12, 5, false), false, []uint64{}},
+		// the playlist has moved on while the segments were being pulled
+		{"moved past", testMediaPlaylist(20, 3, false), false, []uint64{20, 21, 22}},
+		// a finished playlist is pulled from the beginning
+		{"vod", testMediaPlaylist(0, 5, true), true, []uint64{0, 1, 2, 3, 4}},
+	}
+	for _, c := range cases {
+		hr.update(c.media, c.initial)
+		seqs := queuedSequences(hr)
+		if len(seqs) != len(c.expected) {
+			t.Errorf("%s: expected segments %v, got %v", c.name, c.expected, seqs)
+			continue
+		}
+		for i := range seqs {
+			if seqs[i] != c.expected[i] {
+				t.Errorf("%s: expected segments %v, got %v", c.name, c.expected, seqs)
+				break
+			}
+		}
+	}
+}
+
+func TestHLSReaderRead(t *testing.T) {
+	frame := layer3Frame(t, 44100, 128, 2)
+	audio := bytes.Repeat(frame, 4)
+	tagged := func(title string) []byte {
+		return append(id3.Render(id3.TimestampFrame(0), id3.TextFrame("TIT2", title)), audio...)
+	}
+
+	upstream := &testHLSUpstream{
+		playlist: hls.MediaPlaylist{TargetDuration: 1},
+		segments: make(map[string][]byte),
+	}
+	upstream.add("0.mp3", tagged("One"), false)
+	upstream.add("1.mp3", tagged("Two"), true)
+	srv := httptest.NewServer(upstream)
+	defer srv.Close()
+
+	source := newTestPushSource()
+	p := newPuller(source)
+	u, _ := url.Parse(srv.URL + "/live.m3u8")
+	resp, err := p.open(context.Background(), u)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != defaultContentType {
+		t.Errorf("expected content type %s, got %s", defaultContentType, ct)
+	}
+
+	buf := make([]byte, 2*len(audio))
+	readSegment := func(title string) {
+		n, err := resp.Body.Read(buf)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", title, err)
+		}
+		if !bytes.Equal(buf[:n], audio) {
+			t.Errorf("%s: expected the segment audio without tags, got %d bytes", title, n)
+		}
+		// the segment tags are published as soon as the segment starts
+		if meta := source.currentMeta["StreamTitle"]; meta != title {
+			t.Errorf("expected title %q, got %q", title, meta)
+		}
+	}
+	readSegment("One")
+	// the title changes across the discontinuity
+	readSegment("Two")
+
+	// the reader waits for the playlist to get new segments, an untagged
+	// segment keeps the title
+	upstream.add("2.mp3", audio, false)
+	upstream.add("3.aac", adtsFrame(4, 2, 200, 0x7FF), true)
+	readSegment("Two")
+
+	// the stream format may not change across a discontinuity
+	_, err = resp.Body.Read(buf)
+	if err == nil || !strings.Contains(err.Error(), "format changed") {
+		t.Errorf("expected format change error, got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package cast
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	icyDialTimeout   = 10 * time.Second
+	icyHeaderTimeout = 15 * time.Second
+	icyMaxRedirects  = 5
+)
+
+type (
+	// icyResponse is a response of an upstream server speaking HTTP
+	// or SHOUTcast "ICY" protocol
+	icyResponse struct {
+		Proto      string
+		Status     string
+		StatusCode int
+		Header     http.Header
+		Body       io.ReadCloser
+		URL        *url.URL
+	}
+
+	icyBody struct {
+		io.Reader
+		conn net.Conn
+		stop chan struct{}
+	}
+)
+
+func (b *icyBody) Close() error {
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	return b.conn.Close()
+}
+
+// icyGet requests a stream from upstream following redirects. Unlike
+// net/http client it accepts "ICY 200 OK" status lines of SHOUTcast v1 servers
+func icyGet(ctx context.Context, u *url.URL, hdr http.Header) (*icyResponse, error) {
+	for redirects := 0; ; redirects++ {
+		resp, err := icyRequest(ctx, u, hdr)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			resp.Body.Close()
+			if redirects >= icyMaxRedirects {
+				return nil, errors.New("too many redirects")
+			}
+			location := resp.Header.Get("Location")
+			if location == "" {
+				return nil, fmt.Errorf("redirect %d without Location", resp.StatusCode)
+			}
+			next, err := u.Parse(location)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redirect location: %s", err.Error())
+			}
+			u = next
+		default:
+			return resp, nil
+		}
+	}
+}
+
+func icyRequest(ctx context.Context, u *url.URL, hdr http.Header) (*icyResponse, error) {
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "http":
+			host = net.JoinHostPort(u.Hostname(), "80")
+		case "https":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	dialer := &net.Dialer{Timeout: icyDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		conn = tlsConn
+	}
+
+	// closing the connection on context cancellation
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	fail := func(err error) (*icyResponse, error) {
+		close(stop)
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(icyHeaderTimeout))
+
+	var request strings.Builder
+	fmt.Fprintf(&request, "GET %s HTTP/1.0\r\n", u.RequestURI())
+	fmt.Fprintf(&request, "Host: %s\r\n", u.Host)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		request.WriteString("Authorization: " + basicAuth(u.User.Username(), password) + "\r\n")
+	}
+	for name, values := range hdr {
+		for _, value := range values {
+			fmt.Fprintf(&request, "%s: %s\r\n", name, value)
+		}
+	}
+	request.WriteString("\r\n")
+	_, err = io.WriteString(conn, request.String())
+	if err != nil {
+		return fail(err)
+	}
+
+	rd := bufio.NewReader(conn)
+	tp := textproto.NewReader(rd)
+	statusLine, err := tp.ReadLine()
+	if err != nil {
+		return fail(fmt.Errorf("error reading status line: %s", err.Error()))
+	}
+	resp := &icyResponse{URL: u}
+	resp.Proto, resp.Status, resp.StatusCode, err = parseStatusLine(statusLine)
+	if err != nil {
+		return fail(err)
+	}
+
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return fail(fmt.Errorf("error reading headers: %s", err.Error()))
+	}
+	resp.Header = http.Header(mimeHeader)
+	conn.SetDeadline(time.Time{})
+
+	var body io.Reader = rd
+	if strings.ToLower(resp.Header.Get("Transfer-Encoding")) == "chunked" {
+		body = httputil.NewChunkedReader(rd)
+	}
+	resp.Body = &icyBody{body, conn, stop}
+	return resp, nil
+}
+
+// parseStatusLine parses "ICY 200 OK" as well as "HTTP/1.1 200 OK"
+func parseStatusLine(line string) (proto string, status string, code int, err error) {
+	tokens := strings.SplitN(line, " ", 3)
+	if len(tokens) < 2 {
+		return "", "", 0, fmt.Errorf("malformed status line %q", line)
+	}
+	proto = tokens[0]
+	if proto != "ICY" && !strings.HasPrefix(proto, "HTTP/") {
+		return "", "", 0, fmt.Errorf("unknown protocol in status line %q", line)
+	}
+	code, err = strconv.Atoi(tokens[1])
+	if err != nil || code < 100 || code > 999 {
+		return "", "", 0, fmt.Errorf("malformed status code in status line %q", line)
+	}
+	status = strings.Join(tokens[1:], " ")
+	return proto, status, code, nil
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// redactURL returns the URL string with password removed
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	if _, hasPassword := u.User.Password(); !hasPassword {
+		return u.String()
+	}
+	redacted := *u
+	redacted.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return redacted.String()
+}
@@ -0,0 +1,145 @@
+package cast
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseStatusLine(t *testing.T) {
+	cases := []struct {
+		line   string
+		proto  string
+		status string
+		code   int
+		valid  bool
+	}{
+		{"ICY 200 OK", "ICY", "200 OK", 200, true},
+		{"HTTP/1.0 200 OK", "HTTP/1.0", "200 OK", 200, true},
+		{"HTTP/1.1 200 OK", "HTTP/1.1", "200 OK", 200, true},
+		{"HTTP/1.1 200", "HTTP/1.1", "200", 200, true},
+		{"ICY 401 Service Unavailable", "ICY", "401 Service Unavailable", 401, true},
+		{"HTTP/1.1 404 Not Found", "HTTP/1.1", "404 Not Found", 404, true},
+		{"HTTP/1.0 302 Found", "HTTP/1.0", "302 Found", 302, true},
+		{"", "", "", 0, false},
+		{"ICY", "", "", 0, false},
+		{"HTTP/1.1", "", "", 0, false},
+		{"RTSP/1.0 200 OK", "", "", 0, false},
+		{"icy 200 OK", "", "", 0, false},
+		{"HTTP/1.1 OK 200", "", "", 0, false},
+		{"HTTP/1.1 99 Weird", "", "", 0, false},
+		{"HTTP/1.1 1000 Weird", "", "", 0, false},
+		{"<html><body>hello</body></html>", "", "", 0, false},
+	}
+	for _, c := range cases {
+		proto, status, code, err := parseStatusLine(c.line)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%q: expected error", c.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", c.line, err)
+			continue
+		}
+		if proto != c.proto || status != c.status || code != c.code {
+			t.Errorf("%q: expected %q %q %d, got %q %q %d", c.line, c.proto, c.status, c.code, proto, status, code)
+		}
+	}
+}
+
+// serveRaw answers every connection with the raw response once the
+// request headers are read
+func serveRaw(t *testing.T, response string) *url.URL {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			rd := bufio.NewReader(conn)
+			for {
+				line, err := rd.ReadString('\n')
+				if err != nil || line == "\r\n" {
+					break
+				}
+			}
+			conn.Write([]byte(response))
+			conn.Close()
+		}
+	}()
+	u, _ := url.Parse("http://" + ln.Addr().String() + "/stream")
+	return u
+}
+
+func TestIcyRequest(t *testing.T) {
+	cases := []struct {
+		name        string
+		response    string
+		code        int
+		contentType string
+		body        string
+		valid       bool
+	}{
+		{"icy", "ICY 200 OK\r\nicy-name: Test\r\ncontent-type: audio/mpeg\r\n\r\nDATA", 200, "audio/mpeg", "DATA", true},
+		{"http/1.0", "HTTP/1.0 200 OK\r\nContent-Type: audio/aac\r\n\r\nDATA", 200, "audio/aac", "DATA", true},
+		{"http/1.1 chunked", "HTTP/1.1 200 OK\r\nContent-Type: audio/mpeg\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nDA\r\n2\r\nTA\r\n0\r\n\r\n", 200, "audio/mpeg", "DATA", true},
+		{"no headers", "ICY 200 OK\r\n\r\nDATA", 200, "", "DATA", true},
+		{"not found", "HTTP/1.1 404 Not Found\r\nContent-Type: text/plain\r\n\r\nnot found", 404, "text/plain", "not found", true},
+		{"icy unavailable", "ICY 401 Service Unavailable\r\n\r\n", 401, "", "", true},
+		{"malformed", "garbage\r\n\r\n", 0, "", "", false},
+		{"empty", "", 0, "", "", false},
+	}
+	for _, c := range cases {
+		resp, err := icyRequest(context.Background(), serveRaw(t, c.response), http.Header{})
+		if !c.valid {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s: expected error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.code, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != c.contentType {
+			t.Errorf("%s: expected content type %q, got %q", c.name, c.contentType, ct)
+		}
+		if string(body) != c.body {
+			t.Errorf("%s: expected body %q, got %q", c.name, c.body, body)
+		}
+	}
+}
+
+func TestIcyGetRedirect(t *testing.T) {
+	target := serveRaw(t, "ICY 200 OK\r\n\r\nDATA")
+	u := serveRaw(t, "HTTP/1.1 302 Found\r\nLocation: "+target.String()+"\r\n\r\n")
+	resp, err := icyGet(context.Background(), u, http.Header{})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.URL.String() != target.String() {
+		t.Errorf("expected 200 from %s, got %d from %s", target, resp.StatusCode, resp.URL)
+	}
+
+	loop := serveRaw(t, "HTTP/1.1 302 Found\r\nLocation: /stream\r\n\r\n")
+	if _, err := icyGet(context.Background(), loop, http.Header{}); err == nil {
+		t.Error("expected too many redirects error")
+	}
+}
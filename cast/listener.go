@@ -1,7 +1,6 @@
 package cast
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -12,7 +11,6 @@ import (
 	"github.com/viert/endless"
 	"github.com/viert/flamecast/configreader"
 	"github.com/viert/flamecast/icy"
-	"github.com/viert/flamecast/mpeg"
 )
 
 type (
@@ -67,6 +65,12 @@ func (ls *ListenerSlice) remove(lr *Listener) int {
 	return -1
 }
 
+func (ls *ListenerSlice) count() int {
+	ls.Lock()
+	defer ls.Unlock()
+	return len(ls.listeners)
+}
+
 func (ls *ListenerSlice) iter(fn func(*Listener)) {
 	ls.Lock()
 	defer ls.Unlock()
@@ -144,6 +148,23 @@ func checkToken(token string, lr *Listener, checkURL *url.URL) bool {
 	return false
 }
 
+// checkListenerAuth checks the listener token if the source requires one
+func checkListenerAuth(source *Source, lr *Listener) bool {
+	if source.config.BroadcastAuthType != configreader.BroadcastAuthTypeToken {
+		return true
+	}
+	token := extractToken(lr.request)
+	if token == "" {
+		logger.Errorf("Listener %s at source %s has no token, rejecting", lr.key, lr.sourcePath)
+		return false
+	}
+	if !checkToken(token, lr, source.config.BroadcastAuthTokenCheckURL) {
+		logger.Errorf("Listener %s at source %s has invalid token \"%s\", rejecting", lr.key, lr.sourcePath, token)
+		return false
+	}
+	return true
+}
+
 func listenerNotify(lr *Listener, notifyUrl *url.URL, notifyType string) {
 	if notifyUrl == nil {
 		return
@@ -169,29 +190,96 @@ func listenerNotify(lr *Listener, notifyUrl *url.URL, notifyType string) {
 	}()
 }
 
+// fallbackChain returns the source followed by its fallback sources in
+// priority order. The offline placeholder of the first source having one
+// goes last
+func fallbackChain(source *Source) []*Source {
+	chain := []*Source{source}
+	visited := map[*Source]bool{source: true}
+	offline := source.offline
+	for {
+		alt, found := sourcesPathMap[source.config.FallbackPath]
+		if !found || visited[alt] {
+			// cycles are rejected by the config reader anyway
+			break
+		}
+		chain = append(chain, alt)
+		visited[alt] = true
+		if offline == nil {
+			offline = alt.offline
+		}
+		source = alt
+	}
+	if offline != nil {
+		chain = append(chain, offline)
+	}
+	return chain
+}
+
+// activeLevel returns the index of the first active source of the chain
+// or -1 if none of them is active
+func activeLevel(chain []*Source) int {
+	for i, source := range chain {
+		if source.active {
+			return i
+		}
+	}
+	return -1
+}
+
+// demandChain asks the on-demand sources of the chain above the level to
+// connect. It's called each time the chain is evaluated as the idle check
+// of a source counts only its own listeners. A negative level means none
+// of the chain is active
+func demandChain(chain []*Source, level int) {
+	if level < 0 {
+		level = len(chain)
+	}
+	for _, source := range chain[:level] {
+		if source.config.OnDemand {
+			source.puller.Demand()
+		}
+	}
+}
+
+// primeChain starts the on-demand sources of the chain above the first
+// active one and waits for the first of them to get its buffer primed
+func primeChain(chain []*Source) {
+	demandChain(chain, activeLevel(chain))
+	for _, source := range chain {
+		if source.active {
+			return
+		}
+		if source.config.OnDemand {
+			source.puller.waitPrimed(onDemandPrimeTimeout)
+			return
+		}
+	}
+}
+
 func handleListener(rw http.ResponseWriter, req *http.Request) {
 
 	sourcePath := req.URL.Path
 	source, found := sourcesPathMap[sourcePath]
+	// scheduled mounts play the source the schedule currently points to
+	sched := schedulesPathMap[sourcePath]
+	if !found && sched != nil {
+		source = sched.current()
+		found = source != nil
+	}
 	if !found {
 		http.Error(rw, "Source not found", http.StatusNotFound)
 		return
 	}
-	altSource, hasAlt := sourcesPathMap[source.config.FallbackPath]
+	chain := fallbackChain(source)
+	joinedSource := source
+	scheduled := source
 
 	// Setting up listener
 	lr := NewListener(rw, req, sourcePath)
-	if source.config.BroadcastAuthType == configreader.BroadcastAuthTypeToken {
-		token := extractToken(req)
-		if token == "" || !checkToken(token, lr, source.config.BroadcastAuthTokenCheckURL) {
-			if token == "" {
-				logger.Errorf("Listener %s at source %s has no token, rejecting", lr.key, sourcePath)
-			} else {
-				logger.Errorf("Listener %s at source %s has invalid token \"%s\", rejecting", lr.key, sourcePath, token)
-			}
-			http.Error(rw, "Authentication failed", http.StatusUnauthorized)
-			return
-		}
+	if !checkListenerAuth(source, lr) {
+		http.Error(rw, "Authentication failed", http.StatusUnauthorized)
+		return
 	}
 
 	stats.ListenerConnections++
@@ -199,39 +287,71 @@ func handleListener(rw http.ResponseWriter, req *http.Request) {
 	logger.Noticef("SOURCE \"%s\": listener %s has joined", source.config.Path, lr.key)
 	listenerNotify(lr, source.config.BroadcastNotifyEnterURL, "enter")
 
+	// holding the listener until an on-demand source buffer is primed
+	primeChain(chain)
+
 	// Setting up source reader
-	var isAlt bool
+	var level int
 	var srcReader *endless.Reader
 	var currentSource *Source
 	var synced = false
+	var syncBuf []byte
+	var prefix []byte
 	var metaFrame icy.MetaFrame
 	var err error
 	var n int
 	var chunk []byte
 	var buf = make([]byte, listenerBufferSize)
+	// reconnect grace period state
+	var graceStart, silenceStart time.Time
+	var graceFeedStart uint64
+	var silence []byte
+	var silenceDuration, silenceSent time.Duration
+	// the stream data is sent by complete frames while the source may
+	// stop and get silence inserted
+	var aligner *frameAligner
+	var gone bool
 	logger.Debugf("Allocated listener buffer, size=%d", listenerBufferSize)
 
-	if !source.active {
-		if !hasAlt || !altSource.active {
-			http.Error(rw, "source not found", http.StatusNotFound)
-			logger.Errorf("SOURCE \"%s\": listener %s dropped as source is not active and there's no alternative",
-				sourcePath, lr.key)
-			return
+	level = activeLevel(chain)
+	if level < 0 {
+		http.Error(rw, "source not found", http.StatusNotFound)
+		logger.Errorf("SOURCE \"%s\": listener %s dropped as source is not active and there's no alternative",
+			sourcePath, lr.key)
+		return
+	}
+	if level > 0 {
+		logger.Noticef("SOURCE \"%s\": listener %s started with fallback stream %s", sourcePath, lr.key, chain[level].config.Path)
+	}
+	currentSource = chain[level]
+	currentSource.listeners.add(lr)
+	srcReader, prefix = currentSource.newReader()
+	// the content type and the metadata interval are sent with the headers,
+	// the listener can't be moved to a stream of another format afterwards
+	streamFormat := currentSource.format
+
+	// moveTo moves the listener to another source of the fallback chain
+	moveTo := func(to int) bool {
+		if chain[to].format != streamFormat {
+			logger.Errorf("SOURCE \"%s\": %s streams another format, giving up with listener %s",
+				sourcePath, chain[to].config.Path, lr.key)
+			return false
 		}
-		altSource.listeners.add(lr)
-		logger.Noticef("SOURCE \"%s\": listener %s started with fallback stream", sourcePath, lr.key)
-		srcReader = altSource.Buffer.NewReader(altSource.Buffer.MidPoint())
-		isAlt = true
-	} else {
-		source.listeners.add(lr)
-		srcReader = source.Buffer.NewReader(source.Buffer.MidPoint())
-		isAlt = false
+		currentSource.listeners.remove(lr)
+		level = to
+		currentSource = chain[level]
+		currentSource.listeners.add(lr)
+		srcReader, prefix = currentSource.newReader()
+		synced = false
+		syncBuf = nil
+		return true
 	}
 
 	// Setting up listener headers
-	rw.Header().Set("Content-Type", "audio/mpeg")
-	rw.Header().Set("icy-br", fmt.Sprintf("%d", source.config.Stream.Bitrate))
-	rw.Header().Set("ice-audio-info", source.config.Stream.AudioInfo)
+	rw.Header().Set("Content-Type", currentSource.ContentType)
+	// audio parameters are of the stream being actually sent
+	rw.Header().Set("icy-br", fmt.Sprintf("%d", currentSource.config.Stream.Bitrate))
+	rw.Header().Set("ice-audio-info", currentSource.config.Stream.AudioInfo)
 	rw.Header().Set("icy-description", source.config.Stream.Description)
 	rw.Header().Set("icy-name", source.config.Stream.Name)
 	rw.Header().Set("icy-genre", source.config.Stream.Genre)
@@ -245,7 +365,8 @@ func handleListener(rw http.ResponseWriter, req *http.Request) {
 	metaInt := 0
 	metaPtr := 0
 	metaRequested := req.Header.Get("Icy-MetaData")
-	if metaRequested == "1" {
+	// Ogg streams carry metadata in their own comment headers
+	if metaRequested == "1" && currentSource.format != formatOgg {
 		metaInt = defaultMetaInterval
 		logger.Debugf("Icy metadata requested, interval set to %d", defaultMetaInterval)
 	}
@@ -263,37 +384,194 @@ func handleListener(rw http.ResponseWriter, req *http.Request) {
 		return true
 	}
 
-	for {
+	// writeData writes stream data interleaving it with icy metadata if requested
+	writeData := func(chunk []byte) bool {
+		if metaInt == 0 {
+			return writeChunk(chunk)
+		}
+
+		for metaPtr+len(chunk) > metaInt {
+			if lr.currentMetaFrame != currentSource.currentMetaFrame {
+				lr.currentMetaFrame = currentSource.currentMetaFrame
+				metaFrame = *currentSource.currentMetaFrame
+			} else {
+				metaFrame = zeroMetaFrame
+			}
+
+			insertPos := metaInt - metaPtr
+
+			if !writeChunk(chunk[:insertPos]) {
+				return false
+			}
+
+			if !writeChunk(metaFrame) {
+				return false
+			}
+
+			chunk = chunk[insertPos:]
+			metaPtr = 0
+		}
+
+		metaPtr += len(chunk)
+		return writeChunk(chunk)
+	}
 
-		if isAlt {
-			if source.active {
+	// writeStream writes the source data holding back the incomplete
+	// frame at the end if the data needs to be kept frame aligned
+	writeStream := func(chunk []byte) bool {
+		if aligner != nil {
+			chunk, _ = aligner.next(chunk)
+		}
+		return writeData(chunk)
+	}
+
+	// the intro goes through writeData as well to keep the metadata
+	// interval consistent across the intro and the stream
+	if intro := source.config.Intro; intro != nil {
+		if currentSource.introCompatible(intro) {
+			gone = !writeData(intro.Data)
+		} else {
+			logger.Errorf("SOURCE \"%s\": intro %s doesn't match the stream of %s, skipping it for listener %s",
+				sourcePath, intro.Path, currentSource.config.Path, lr.key)
+		}
+	}
+
+	for !gone {
+
+		if sched != nil {
+			if target := sched.current(); target != nil && target != scheduled {
+				scheduled = target
+			}
+			if scheduled != source {
+				// the listener stays where it is until the scheduled
+				// source or one of its fallbacks is active
+				newChain := fallbackChain(scheduled)
+				best := activeLevel(newChain)
+				demandChain(newChain, best)
+				if best >= 0 {
+					logger.Noticef("SOURCE \"%s\": schedule switched to %s, moving listener %s to %s",
+						sourcePath, scheduled.config.Path, lr.key, newChain[best].config.Path)
+					chain = newChain
+					source = scheduled
+					graceStart = time.Time{}
+					if !moveTo(best) {
+						break
+					}
+				}
+			}
+		}
+
+		if level > 0 {
+			// listeners always sit on the highest priority active source
+			demandChain(chain, level)
+			best := activeLevel(chain[:level+1])
+			if best == 0 {
 				logger.Noticef("SOURCE \"%s\": source got active, moving listener %s back from fallback",
 					sourcePath, lr.key)
-				srcReader = source.Buffer.NewReader(source.Buffer.MidPoint())
-				synced = false
-				isAlt = false
-				altSource.listeners.remove(lr)
-				source.listeners.add(lr)
-			} else if !altSource.active {
-				altSource.listeners.remove(lr)
-				logger.Errorf("SOURCE \"%s\": no more active sources for listener %s, giving up", sourcePath, lr.key)
-				break
+				if !moveTo(best) {
+					break
+				}
+			} else if best > 0 && best < level {
+				logger.Noticef("SOURCE \"%s\": fallback %s got active, moving listener %s up from fallback %s",
+					sourcePath, chain[best].config.Path, lr.key, currentSource.config.Path)
+				if !moveTo(best) {
+					break
+				}
+			} else if best < 0 {
+				primeChain(chain)
+				best = activeLevel(chain)
+				if best < 0 {
+					logger.Errorf("SOURCE \"%s\": no more active sources for listener %s, giving up", sourcePath, lr.key)
+					break
+				}
+				logger.Noticef("SOURCE \"%s\": fallback %s has stopped, moving listener %s down to fallback %s",
+					sourcePath, currentSource.config.Path, lr.key, chain[best].config.Path)
+				if !moveTo(best) {
+					break
+				}
 			}
 		} else {
+			if !source.active && source.config.GracePeriod > 0 {
+				if graceStart.IsZero() {
+					logger.Noticef("SOURCE \"%s\": source has stopped, listener %s waits for it for %s",
+						sourcePath, lr.key, source.config.GracePeriod)
+					graceStart = time.Now()
+					graceFeedStart = source.feedStart
+					silenceStart = time.Time{}
+					silenceSent = 0
+					silence = nil
+					if source.config.GraceSilence {
+						silence, silenceDuration = source.silenceFrame()
+					}
+				}
+				if time.Since(graceStart) < source.config.GracePeriod {
+					// the rest of the stopped feeder data goes first unless
+					// a new feeder is already filling the buffer
+					if source.feedStart == graceFeedStart {
+						n, err = srcReader.Read(buf)
+						if err != nil {
+							logger.Errorf("SOURCE \"%s\": error reading source buffer: %s", sourcePath, err.Error())
+							break
+						}
+						if n > 0 && synced {
+							if !writeStream(buf[:n]) {
+								break
+							}
+							continue
+						}
+					}
+					// filling the gap with silence in real time
+					if silenceStart.IsZero() {
+						silenceStart = time.Now()
+					}
+					for silence != nil && silenceSent < time.Since(silenceStart) {
+						if !writeData(silence) {
+							gone = true
+							break
+						}
+						silenceSent += silenceDuration
+					}
+					if gone {
+						break
+					}
+					time.Sleep(30 * time.Millisecond)
+					continue
+				}
+			}
+			if source.active && !graceStart.IsZero() {
+				logger.Noticef("SOURCE \"%s\": source is back within the grace period, listener %s resumes",
+					sourcePath, lr.key)
+				graceStart = time.Time{}
+				if source.feedStart != graceFeedStart {
+					// the unread data of the stopped feeder is dropped,
+					// the listener goes on from the new feeder data start
+					if source.format == formatOgg {
+						srcReader, prefix = source.newReader()
+					} else {
+						srcReader = source.Buffer.NewReader(source.feedStart)
+					}
+				}
+				synced = false
+				syncBuf = nil
+			}
 			if !source.active {
-				if altSource == nil {
+				graceStart = time.Time{}
+				if len(chain) == 1 {
 					logger.Noticef("SOURCE \"%s\": source has stopped, no alternative source is defined, giving up with listener %s",
 						sourcePath, lr.key)
 					break
-				} else {
-					logger.Noticef("SOURCE \"%s\": source has stopped, moving listener %s to fallback",
-						sourcePath, lr.key)
-					source.listeners.remove(lr)
-					altSource.listeners.add(lr)
 				}
-				srcReader = altSource.Buffer.NewReader(altSource.Buffer.MidPoint())
-				synced = false
-				isAlt = true
+				primeChain(chain)
+				best := activeLevel(chain)
+				if best < 0 {
+					logger.Errorf("SOURCE \"%s\": no more active sources for listener %s, giving up", sourcePath, lr.key)
+					break
+				}
+				logger.Noticef("SOURCE \"%s\": source has stopped, moving listener %s to fallback %s",
+					sourcePath, lr.key, chain[best].config.Path)
+				if !moveTo(best) {
+					break
+				}
 			}
 		}
 
@@ -310,74 +588,39 @@ func handleListener(rw http.ResponseWriter, req *http.Request) {
 		}
 
 		if !synced {
-			chunk, err = frameSync(buf[:n])
+			syncBuf = append(syncBuf, buf[:n]...)
+			chunk, err = currentSource.sync(syncBuf)
+			if err == errSyncNeedMore && len(syncBuf) < maxSyncBufferSize {
+				syncBuf = chunk
+				continue
+			}
 			if err != nil {
-				logger.Errorf("error framesyncing")
+				logger.Errorf("error framesyncing: %s", err.Error())
 				break
 			}
 			synced = true
-		} else {
-			chunk = buf[:n]
-		}
-
-		if metaInt > 0 {
-			if metaPtr+len(chunk) > metaInt {
-
-				if isAlt {
-					currentSource = altSource
-				} else {
-					currentSource = source
-				}
-
-				if lr.currentMetaFrame != currentSource.currentMetaFrame {
-					lr.currentMetaFrame = currentSource.currentMetaFrame
-					metaFrame = *currentSource.currentMetaFrame
-				} else {
-					metaFrame = zeroMetaFrame
-				}
-
-				// nch := make([]byte, len(chunk)+len(metaFrame))
-				insertPos := metaInt - metaPtr
-
-				if !writeChunk(chunk[:insertPos]) {
-					break
-				}
-
-				if !writeChunk(metaFrame) {
-					break
-				}
-
-				if !writeChunk(chunk[insertPos:]) {
-					break
-				}
-
-				metaPtr = len(chunk) - insertPos
-			} else {
-				metaPtr += len(chunk)
-				if !writeChunk(chunk) {
+			syncBuf = nil
+			aligner = nil
+			if level == 0 && source.config.GracePeriod > 0 {
+				aligner = &frameAligner{format: currentSource.format}
+			}
+			if len(prefix) > 0 {
+				if !writeData(prefix) {
 					break
 				}
+				prefix = nil
 			}
 		} else {
-			if !writeChunk(chunk) {
-				break
-			}
+			chunk = buf[:n]
 		}
 
-	}
-	// removing listeners from all sources whereever he may currently be
-	source.listeners.remove(lr)
-	if hasAlt {
-		altSource.listeners.remove(lr)
-	}
-	listenerNotify(lr, source.config.BroadcastNotifyLeaveURL, "leave")
-}
-
-func frameSync(chunk []byte) ([]byte, error) {
-	for i := 0; i < len(chunk)-4; i++ {
-		if mpeg.FrameHeaderValid(chunk[i:]) {
-			return chunk[i:], nil
+		if !writeStream(chunk) {
+			break
 		}
+
 	}
-	return chunk, errors.New("no valid frame found")
+	// the chain may have been replaced by the schedule, the listener
+	// is removed from the source it currently sits on
+	currentSource.listeners.remove(lr)
+	listenerNotify(lr, joinedSource.config.BroadcastNotifyLeaveURL, "leave")
 }
@@ -0,0 +1,347 @@
+package cast
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var errTestListenerGone = errors.New("listener has gone")
+
+// testListener is the response writer of a listener blocking on every
+// write until the test lets it go on, so the sources may be changed while
+// the listener isn't looking at them
+type testListener struct {
+	header http.Header
+	chunks chan []byte
+	acks   chan error
+	done   chan struct{}
+}
+
+// serveTestListener runs the listener of the path in background
+func serveTestListener(path string) *testListener {
+	lt := &testListener{
+		header: make(http.Header),
+		chunks: make(chan []byte),
+		acks:   make(chan error),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		handleListener(lt, httptest.NewRequest("GET", path, nil))
+		close(lt.done)
+	}()
+	return lt
+}
+
+func (lt *testListener) Header() http.Header {
+	return lt.header
+}
+
+func (lt *testListener) WriteHeader(int) {}
+
+func (lt *testListener) Write(data []byte) (int, error) {
+	lt.chunks <- append([]byte(nil), data...)
+	if err := <-lt.acks; err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// next returns the marker of the next chunk written by the listener. The
+// listener is blocked until resume or leave is called
+func (lt *testListener) next(t *testing.T) byte {
+	select {
+	case chunk := <-lt.chunks:
+		return chunkMarker(chunk)
+	case <-lt.done:
+		t.Fatal("listener is expected to get more data")
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener got no data")
+	}
+	return 0
+}
+
+func (lt *testListener) resume() {
+	lt.acks <- nil
+}
+
+// skipTo lets the listener go on until it gets a chunk with the marker
+// failing on the unexpected ones on the way
+func (lt *testListener) skipTo(t *testing.T, marker byte, unexpected ...byte) {
+	for i := 0; i < 100; i++ {
+		lt.resume()
+		next := lt.next(t)
+		if next == marker {
+			return
+		}
+		for _, u := range unexpected {
+			if next == u {
+				t.Fatalf("listener got data marked %#x before %#x", next, marker)
+			}
+		}
+	}
+	t.Fatalf("listener got no data marked %#x", marker)
+}
+
+// leave disconnects the blocked listener
+func (lt *testListener) leave(t *testing.T) {
+	lt.acks <- errTestListenerGone
+	lt.waitGone(t)
+}
+
+// waitGone waits for the listener to be given up by the server
+func (lt *testListener) waitGone(t *testing.T) {
+	select {
+	case <-lt.done:
+	case chunk := <-lt.chunks:
+		lt.acks <- errTestListenerGone
+		t.Errorf("listener is expected to be given up, got data marked %#x", chunkMarker(chunk))
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener is expected to be given up")
+	}
+}
+
+// chunkMarker returns the most common byte of the chunk, i.e. the payload
+// byte of the marked frames or zero for silence
+func chunkMarker(chunk []byte) byte {
+	var counts [256]int
+	for _, b := range chunk {
+		counts[b]++
+	}
+	marker := 0
+	for b, count := range counts {
+		if count > counts[marker] {
+			marker = b
+		}
+	}
+	return byte(marker)
+}
+
+// markFrame fills the frame payload with the marker
+func markFrame(frame []byte, headerSize int, marker byte) []byte {
+	frame = append([]byte(nil), frame...)
+	for i := headerSize; i < len(frame); i++ {
+		frame[i] = marker
+	}
+	return frame
+}
+
+// newTestMount returns an active source of the path filled with frames
+// of the content type marked with the marker
+func newTestMount(t *testing.T, path string, contentType string, marker byte) *Source {
+	source := newTestPushSource()
+	source.config.Path = path
+	source.setContentType(contentType)
+	feedTestMount(t, source, marker)
+	source.active = true
+	sourcesPathMap[path] = source
+	return source
+}
+
+// feedTestMount writes marked frames of the source format to the source
+func feedTestMount(t *testing.T, source *Source, marker byte) {
+	var frame []byte
+	if source.format == formatAAC {
+		frame = markFrame(adtsFrame(4, 2, 371, 0x100), 7, marker)
+	} else {
+		frame = markFrame(layer3Frame(t, 44100, 128, 2), 4, marker)
+	}
+	source.write(bytes.Repeat(frame, 40))
+}
+
+// withTestMounts runs the test with the sources and schedules of its own
+func withTestMounts(fn func()) {
+	sources, schedules := sourcesPathMap, schedulesPathMap
+	defer func() {
+		sourcesPathMap, schedulesPathMap = sources, schedules
+	}()
+	sourcesPathMap = make(map[string]*Source)
+	schedulesPathMap = make(map[string]*scheduler)
+	fn()
+}
+
+func TestListenerPlaceholderFormat(t *testing.T) {
+	withTestMounts(func() {
+		live := newTestMount(t, "/live", "audio/aac", 0x11)
+		live.active = false
+		live.offline = newTestMount(t, "/live.offline", "audio/mpeg", 0x22)
+		delete(sourcesPathMap, "/live.offline")
+
+		lt := serveTestListener("/live")
+		if marker := lt.next(t); marker != 0x22 {
+			t.Fatalf("expected the placeholder data, got data marked %#x", marker)
+		}
+		if ct := lt.header.Get("Content-Type"); ct != "audio/mpeg" {
+			t.Errorf("expected the placeholder content type, got %s", ct)
+		}
+
+		// the MP3 listener can't get the AAC stream
+		live.active = true
+		lt.resume()
+		lt.waitGone(t)
+	})
+}
+
+func TestFallbackChain(t *testing.T) {
+	withTestMounts(func() {
+		live := newTestMount(t, "/live", "audio/mpeg", 0x11)
+		relay := newTestMount(t, "/relay", "audio/mpeg", 0x22)
+		autodj := newTestMount(t, "/autodj", "audio/mpeg", 0x33)
+		offline := newTestPushSource()
+		live.config.FallbackPath = "/relay"
+		relay.config.FallbackPath = "/autodj"
+		relay.offline = offline
+		// a cycle is rejected by the config reader but must not hang
+		autodj.config.FallbackPath = "/relay"
+
+		chain := fallbackChain(live)
+		expected := []*Source{live, relay, autodj, offline}
+		if len(chain) != len(expected) {
+			t.Fatalf("expected chain of %d sources, got %d", len(expected), len(chain))
+		}
+		for i := range expected {
+			if chain[i] != expected[i] {
+				t.Errorf("unexpected source %s at level %d", chain[i].config.Path, i)
+			}
+		}
+
+		if level := activeLevel(chain); level != 0 {
+			t.Errorf("expected level 0, got %d", level)
+		}
+		live.active = false
+		relay.active = false
+		if level := activeLevel(chain); level != 2 {
+			t.Errorf("expected level 2, got %d", level)
+		}
+		autodj.active = false
+		if level := activeLevel(chain); level != -1 {
+			t.Errorf("expected no active level, got %d", level)
+		}
+	})
+}
+
+func TestListenerFallback(t *testing.T) {
+	withTestMounts(func() {
+		live := newTestMount(t, "/live", "audio/mpeg", 0x11)
+		relay := newTestMount(t, "/relay", "audio/mpeg", 0x22)
+		live.config.FallbackPath = "/relay"
+
+		lt := serveTestListener("/live")
+		if marker := lt.next(t); marker != 0x11 {
+			t.Fatalf("expected the live data, got data marked %#x", marker)
+		}
+		live.active = false
+		lt.skipTo(t, 0x22)
+
+		// back to the live source as soon as it's active
+		live.active = true
+		relay.active = false
+		lt.skipTo(t, 0x11)
+		lt.leave(t)
+	})
+}
+
+func TestListenerFallbackFormat(t *testing.T) {
+	withTestMounts(func() {
+		live := newTestMount(t, "/live", "audio/mpeg", 0x11)
+		newTestMount(t, "/relay", "audio/aac", 0x22)
+		live.config.FallbackPath = "/relay"
+
+		lt := serveTestListener("/live")
+		if marker := lt.next(t); marker != 0x11 {
+			t.Fatalf("expected the live data, got data marked %#x", marker)
+		}
+		// the MP3 listener can't be moved to the AAC relay
+		live.active = false
+		lt.resume()
+		lt.waitGone(t)
+	})
+}
+
+func TestListenerGracePeriod(t *testing.T) {
+	withTestMounts(func() {
+		live := newTestMount(t, "/live", "audio/mpeg", 0x11)
+		newTestMount(t, "/relay", "audio/mpeg", 0x22)
+		live.config.FallbackPath = "/relay"
+		live.config.GracePeriod = time.Second
+		live.config.GraceSilence = true
+
+		lt := serveTestListener("/live")
+		if marker := lt.next(t); marker != 0x11 {
+			t.Fatalf("expected the live data, got data marked %#x", marker)
+		}
+
+		// the listener gets silence while the feeder is away
+		live.active = false
+		lt.skipTo(t, 0, 0x22)
+
+		// and the new feeder data once it's back
+		live.setContentType("audio/mpeg")
+		feedTestMount(t, live, 0x33)
+		live.active = true
+		lt.skipTo(t, 0x33, 0x22)
+		lt.leave(t)
+	})
+}
+
+func TestListenerGracePeriodTimeout(t *testing.T) {
+	withTestMounts(func() {
+		live := newTestMount(t, "/live", "audio/mpeg", 0x11)
+		newTestMount(t, "/relay", "audio/mpeg", 0x22)
+		live.config.FallbackPath = "/relay"
+		live.config.GracePeriod = 200 * time.Millisecond
+		live.config.GraceSilence = true
+
+		lt := serveTestListener("/live")
+		if marker := lt.next(t); marker != 0x11 {
+			t.Fatalf("expected the live data, got data marked %#x", marker)
+		}
+
+		// the listener is moved to the fallback once the grace period is over
+		live.active = false
+		stopped := time.Now()
+		lt.skipTo(t, 0x22)
+		if elapsed := time.Since(stopped); elapsed < live.config.GracePeriod {
+			t.Errorf("listener is expected to wait for the grace period, moved after %s", elapsed)
+		}
+		lt.leave(t)
+	})
+}
+
+func TestListenerGracePeriodReadError(t *testing.T) {
+	withTestMounts(func() {
+		live := newTestMount(t, "/live", "audio/mpeg", 0x11)
+		live.config.GracePeriod = time.Minute
+		live.config.GraceSilence = true
+
+		lt := serveTestListener("/live")
+		if marker := lt.next(t); marker != 0x11 {
+			t.Fatalf("expected the live data, got data marked %#x", marker)
+		}
+		live.active = false
+		lt.skipTo(t, 0)
+
+		// the listener reader falls behind the buffer, the silence being
+		// sent already goes first
+		live.Buffer.Write(make([]byte, endlessSize))
+		live.Buffer.Write(make([]byte, endlessSize))
+		deadline := time.After(time.Second)
+		for {
+			lt.resume()
+			select {
+			case <-lt.done:
+				return
+			case chunk := <-lt.chunks:
+				if marker := chunkMarker(chunk); marker != 0 {
+					lt.acks <- errTestListenerGone
+					t.Fatalf("listener is expected to be given up, got data marked %#x", marker)
+				}
+			case <-deadline:
+				t.Fatal("listener is expected to be given up")
+			}
+		}
+	})
+}
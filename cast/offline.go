@@ -0,0 +1,100 @@
+package cast
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/mpeg"
+)
+
+// placeholder silence stream defaults for the parameters not configured
+const (
+	offlineSampleRate = 44100
+	offlineChannels   = 2
+)
+
+// checkOffline makes sure the placeholder matches the declared stream
+// format of the mount. Both the file and the silence are MPEG only
+func checkOffline(sc *configreader.SourceConfig) error {
+	if formatFromContentType(sc.ContentType) != formatMPEG {
+		return fmt.Errorf("offline placeholder is only supported for MPEG streams, source %s is %s",
+			sc.Name, sc.ContentType)
+	}
+	return nil
+}
+
+// newOfflineSource returns the placeholder source looped to the mount
+// listeners while none of the sources of its fallback chain is active.
+// It's not reachable by its own path
+func newOfflineSource(sc *configreader.SourceConfig) *Source {
+	oc := &configreader.SourceConfig{
+		Name:             sc.Name,
+		Path:             sc.Path + " (offline)",
+		Type:             configreader.SourceTypePlaylist,
+		PlaylistPath:     sc.OfflineFile,
+		PlaylistRepeat:   true,
+		Stream:           sc.Stream,
+		ConfiguredStream: sc.ConfiguredStream,
+	}
+	source := NewSource(oc)
+	if sc.OfflineFile == "" {
+		// generated silence
+		source.autodj = nil
+	}
+	return source
+}
+
+// runSilence writes silent MPEG frames to the source in real time forever
+func runSilence(source *Source) {
+	stream := source.config.Stream
+	sampleRate := stream.SampleRate
+	if sampleRate == 0 {
+		sampleRate = offlineSampleRate
+	}
+	channels := stream.Channels
+	if channels == 0 {
+		channels = offlineChannels
+	}
+	hdr, err := mpeg.NewLayer3Header(sampleRate, stream.Bitrate, channels)
+	if err != nil {
+		logger.Errorf("SOURCE \"%s\": can't generate silence at %d Hz, %d kbps, %d channels: %s",
+			source.config.Path, sampleRate, stream.Bitrate, channels, err.Error())
+		return
+	}
+	frame := mpeg.SilentFrame(hdr)
+	frameDuration := time.Duration(hdr.NumSamples()) * time.Second / time.Duration(sampleRate)
+
+	source.setContentType(defaultContentType)
+	block := make([]byte, 0, dataBufferSize+len(frame))
+	clock := time.Now()
+	var elapsed time.Duration
+	written := 0
+	for {
+		block = block[:0]
+		for len(block) < dataBufferSize {
+			block = append(block, frame...)
+			elapsed += frameDuration
+		}
+		source.write(block)
+		if !source.active {
+			written++
+			if written == blocksWrittenUntilActive {
+				logger.Noticef("SOURCE \"%s\": source buffer filled, source is now active", source.config.Path)
+				source.active = true
+				source.Started = time.Now()
+			}
+		}
+		// keeping a second ahead of real time like the playlist sources do
+		time.Sleep(time.Until(clock.Add(elapsed - autoDJLeadTime)))
+	}
+}
+
+// runOffline runs the placeholder source forever
+func (s *Source) runOffline() {
+	if s.autodj != nil {
+		s.autodj.run()
+	} else {
+		runSilence(s)
+	}
+}
@@ -0,0 +1,25 @@
+package cast
+
+import (
+	"testing"
+
+	"github.com/viert/flamecast/configreader"
+)
+
+func TestCheckOffline(t *testing.T) {
+	cases := []struct {
+		contentType string
+		valid       bool
+	}{
+		{"", true},
+		{"audio/mpeg", true},
+		{"audio/aac", false},
+		{"application/ogg", false},
+	}
+	for _, c := range cases {
+		sc := &configreader.SourceConfig{Name: "live", ContentType: c.contentType, OfflineSilence: true}
+		if err := checkOffline(sc); (err == nil) != c.valid {
+			t.Errorf("%q: expected valid %v, got error %v", c.contentType, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cast
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// how far ahead of real time a paced feeder may get
+	pacingLeadTime = time.Second
+	// how far behind real time a paced feeder may get before
+	// the pacing clock is started over
+	pacingMaxBehind = 2 * time.Second
+)
+
+type (
+	// PacingDesc describes json representation of the feeder pacing state.
+	// Positive values mean the feeder is ahead of real time
+	PacingDesc struct {
+		Ahead     float64 `json:"ahead_seconds"`
+		MaxAhead  float64 `json:"max_ahead_seconds"`
+		Held      float64 `json:"held_seconds"`
+		Underruns uint64  `json:"underruns"`
+	}
+
+	// pacer holds the feeder back to real-time speed measured by the MPEG
+	// (or ADTS) frame durations. The feeder gets blocked (and throttled
+	// by TCP) while it's ahead
+	pacer struct {
+		sync.Mutex
+		clock     time.Time
+		elapsed   time.Duration
+		ahead     time.Duration
+		maxAhead  time.Duration
+		held      time.Duration
+		underruns uint64
+	}
+)
+
+// newPacer returns a pacer for the source or nil if the source stream
+// format has no frames to measure
+func newPacer(source *Source) *pacer {
+	if source.format != formatMPEG && source.format != formatAAC {
+		return nil
+	}
+	return &pacer{}
+}
+
+// Desc returns the pacing state description
+func (pc *pacer) Desc() *PacingDesc {
+	pc.Lock()
+	defer pc.Unlock()
+	return &PacingDesc{
+		Ahead:     pc.ahead.Seconds(),
+		MaxAhead:  pc.maxAhead.Seconds(),
+		Held:      pc.held.Seconds(),
+		Underruns: pc.underruns,
+	}
+}
+
+// hold accounts the duration of the frames just written to the source and
+// waits until the source is no more than pacingLeadTime ahead of real time
+func (pc *pacer) hold(duration time.Duration) {
+	now := time.Now()
+	pc.Lock()
+	if pc.clock.IsZero() {
+		pc.clock = now
+	}
+	pc.elapsed += duration
+	ahead := pc.clock.Add(pc.elapsed).Sub(now)
+	if ahead < -pacingMaxBehind {
+		// the feeder has stalled, there's no point in letting it burst
+		pc.underruns++
+		pc.clock = now.Add(-pc.elapsed)
+		ahead = 0
+	}
+	pc.ahead = ahead
+	if ahead > pc.maxAhead {
+		pc.maxAhead = ahead
+	}
+	wait := ahead - pacingLeadTime
+	if wait > 0 {
+		pc.held += wait
+	}
+	pc.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
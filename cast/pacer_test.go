@@ -0,0 +1,130 @@
+package cast
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPacerHold(t *testing.T) {
+	pc := &pacer{}
+
+	// the feeder may get up to a second ahead of real time
+	start := time.Now()
+	pc.hold(800 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected no wait within the lead time, waited %s", elapsed)
+	}
+	pc.hold(400 * time.Millisecond)
+	elapsed := time.Since(start)
+	if elapsed < 150*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("expected to be held for about 200ms, waited %s", elapsed)
+	}
+	pd := pc.Desc()
+	if pd.MaxAhead < 1.1 || pd.Held < 0.15 || pd.Underruns != 0 {
+		t.Errorf("unexpected pacing state %+v", pd)
+	}
+}
+
+func TestPacerBehind(t *testing.T) {
+	pc := &pacer{}
+	pc.hold(100 * time.Millisecond)
+
+	// less than 2s behind, the feeder may catch up
+	pc.Lock()
+	pc.clock = pc.clock.Add(-1500 * time.Millisecond)
+	pc.Unlock()
+	pc.hold(100 * time.Millisecond)
+	pd := pc.Desc()
+	if pd.Underruns != 0 || pd.Ahead > -1.2 {
+		t.Errorf("expected the feeder to be behind with no underrun, got %+v", pd)
+	}
+
+	// more than 2s behind, the clock is started over
+	pc.Lock()
+	pc.clock = pc.clock.Add(-time.Second)
+	pc.Unlock()
+	pc.hold(100 * time.Millisecond)
+	pd = pc.Desc()
+	if pd.Underruns != 1 || pd.Ahead != 0 {
+		t.Errorf("expected an underrun resetting the clock, got %+v", pd)
+	}
+
+	// no burst allowed after the reset
+	start := time.Now()
+	pc.hold(1200 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected to be held after the reset, waited %s", elapsed)
+	}
+}
+
+func TestFeedSourcePacerCleared(t *testing.T) {
+	source := newTestPushSource()
+	source.config.Pacing = true
+	frame := make([]byte, 417)
+	copy(frame, testFrames[:4])
+
+	sr := &pacerCheckReader{source: source, data: frame}
+	feedSource(source, sr, nil)
+	if !sr.paced {
+		t.Error("expected the source to have a pacer while fed")
+	}
+	if source.feeders.Pacer() != nil {
+		t.Error("expected the pacer to be cleared when the feeder is gone")
+	}
+}
+
+// pacerCheckReader returns the data once and records if the source
+// had a pacer while being read
+type pacerCheckReader struct {
+	source *Source
+	data   []byte
+	paced  bool
+}
+
+func (r *pacerCheckReader) Read(buf []byte) (int, error) {
+	r.paced = r.source.feeders.Pacer() != nil
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(buf, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestFeedSourcePacerStandby(t *testing.T) {
+	source := newTestPushSource()
+	source.config.Pacing = true
+	primary := newFeeder("primary", false, false)
+	backup := newFeeder("backup", false, true)
+	attachTestFeeder(t, source, primary)
+	attachTestFeeder(t, source, backup)
+	frame := make([]byte, 417)
+	copy(frame, testFrames[:4])
+
+	feed := func(f *feeder) (*io.PipeWriter, chan struct{}) {
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			feedSource(source, pr, f)
+			close(done)
+		}()
+		// the pacer is set up once the feeder data is read
+		pw.Write(frame)
+		return pw, done
+	}
+	pw, primaryDone := feed(primary)
+	bw, backupDone := feed(backup)
+
+	// the backup feeder leaving doesn't affect the streaming primary
+	bw.Close()
+	<-backupDone
+	source.feeders.Lock()
+	expected := primary.pacer
+	source.feeders.Unlock()
+	if pc := source.feeders.Pacer(); pc == nil || pc != expected {
+		t.Error("expected the pacer of the primary feeder")
+	}
+	pw.Close()
+	<-primaryDone
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package cast
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command start a process group of its own
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the started command and all of its children
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,11 @@
+package cast
+
+import "os/exec"
+
+// setProcessGroup does nothing as there are no process groups on windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the started command only
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
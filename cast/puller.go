@@ -0,0 +1,581 @@
+package cast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/icy"
+	"github.com/viert/flamecast/playlist"
+)
+
+// Puller states
+const (
+	PullerStateConnecting = "connecting"
+	PullerStateConnected  = "connected"
+	PullerStateBackingOff = "backing_off"
+	PullerStateIdle       = "idle"
+)
+
+const (
+	probeTimeout    = 10 * time.Second
+	maxPlaylistSize = 1024 * 1024
+	// how long a listener of an on-demand source waits for it to start
+	onDemandPrimeTimeout = 10 * time.Second
+	idleCheckInterval    = time.Second
+)
+
+var (
+	errReconnectRequested = errors.New("reconnect requested")
+	errIdle               = errors.New("no listeners")
+)
+
+type (
+	// PullerDesc describes json representation of a puller state
+	PullerDesc struct {
+		State       string     `json:"state"`
+		LastError   string     `json:"last_error"`
+		LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+		NextAttempt *time.Time `json:"next_attempt,omitempty"`
+		Failures    int        `json:"failures"`
+		Connections uint64     `json:"connections"`
+		Upstream    string     `json:"upstream"`
+		Resolved    string     `json:"resolved"`
+	}
+
+	// puller keeps a PULL source connected to its upstream forever (or
+	// while it's listened to if the source is on-demand), reconnecting
+	// with exponential backoff
+	puller struct {
+		sync.Mutex
+		source      *Source
+		state       string
+		lastError   error
+		lastErrorAt time.Time
+		nextAttempt time.Time
+		failures    int
+		connections uint64
+		upstream    int
+		resolved    *url.URL
+		cancel      context.CancelFunc
+		wakeup      chan struct{}
+		demand      chan struct{}
+		lastDemand  time.Time
+		idled       bool
+		// closed when the source buffer gets primed
+		primed chan struct{}
+	}
+)
+
+func newPuller(source *Source) *puller {
+	return &puller{
+		source:   source,
+		state:    PullerStateConnecting,
+		upstream: -1,
+		wakeup:   make(chan struct{}, 1),
+		demand:   make(chan struct{}, 1),
+		primed:   make(chan struct{}),
+	}
+}
+
+func (p *puller) setState(state string) {
+	p.Lock()
+	defer p.Unlock()
+	p.state = state
+}
+
+func (p *puller) recordError(err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.lastError = err
+	p.lastErrorAt = time.Now()
+	logger.Errorf("SOURCE \"%s\": %s", p.source.config.Path, err.Error())
+}
+
+func (p *puller) fail(err error) {
+	p.recordError(err)
+	p.Lock()
+	defer p.Unlock()
+	p.failures++
+}
+
+// retryDelay returns the exponential backoff delay after a number
+// of consecutive failures
+func retryDelay(rc configreader.RetryConfig, failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	delay := float64(rc.Initial) * math.Pow(rc.Factor, float64(failures-1))
+	if delay > float64(rc.Max) {
+		delay = float64(rc.Max)
+	}
+	delay += delay * rc.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// disconnected resets the backoff if the upstream connection has been
+// healthy, i.e. lasted for at least the configured time
+func (p *puller) disconnected(connected time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	if connected > 0 && connected >= p.source.config.Retry.Healthy {
+		// starting over with the retry budget
+		p.failures = 0
+	}
+}
+
+// backoff returns the delay before the next connection attempt
+func (p *puller) backoff() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return retryDelay(p.source.config.Retry, p.failures)
+}
+
+// Reconnect drops the current upstream connection (if any) and makes
+// the puller connect again immediately
+func (p *puller) Reconnect() {
+	p.Lock()
+	p.failures = 0
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.Unlock()
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Demand makes an idle on-demand puller connect to upstream and keeps
+// a connected one from going idle
+func (p *puller) Demand() {
+	p.Lock()
+	p.lastDemand = time.Now()
+	p.Unlock()
+	select {
+	case p.demand <- struct{}{}:
+	default:
+	}
+}
+
+// idle returns true if the on-demand source has had no listeners
+// for the idle timeout
+func (p *puller) idle() bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.source.listeners.count() > 0 {
+		p.lastDemand = time.Now()
+		return false
+	}
+	return time.Since(p.lastDemand) >= p.source.config.IdleTimeout
+}
+
+// waitDemand waits for a listener to show up
+func (p *puller) waitDemand() {
+	select {
+	case <-p.demand:
+	default:
+	}
+	if !p.idle() {
+		return
+	}
+	p.setState(PullerStateIdle)
+	logger.Noticef("SOURCE \"%s\": on-demand source is idle, waiting for listeners", p.source.config.Path)
+	<-p.demand
+	logger.Noticef("SOURCE \"%s\": on-demand source is requested by a listener", p.source.config.Path)
+}
+
+// signalPrimed wakes up the listeners waiting for the source to start
+func (p *puller) signalPrimed() {
+	p.Lock()
+	defer p.Unlock()
+	close(p.primed)
+}
+
+// resetPrimed makes the listeners wait again after the source has stopped.
+// The channel is kept if the source hasn't got primed as it may be waited for
+func (p *puller) resetPrimed() {
+	p.Lock()
+	defer p.Unlock()
+	select {
+	case <-p.primed:
+		p.primed = make(chan struct{})
+	default:
+	}
+}
+
+// waitPrimed waits for the source buffer to get primed for the timeout
+// and returns true if the source is active
+func (p *puller) waitPrimed(timeout time.Duration) bool {
+	p.Lock()
+	primed := p.primed
+	p.Unlock()
+	if p.source.active {
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-primed:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// watchIdle disconnects the on-demand source from upstream as soon as
+// it gets idle
+func (p *puller) watchIdle(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if p.idle() {
+			p.Lock()
+			p.idled = true
+			p.Unlock()
+			cancel()
+			return
+		}
+	}
+}
+
+// Desc returns the puller state description
+func (p *puller) Desc() *PullerDesc {
+	p.Lock()
+	defer p.Unlock()
+	pd := &PullerDesc{
+		State:       p.state,
+		Failures:    p.failures,
+		Connections: p.connections,
+	}
+	if p.lastError != nil {
+		pd.LastError = p.lastError.Error()
+		errorAt := p.lastErrorAt
+		pd.LastErrorAt = &errorAt
+	}
+	if p.state == PullerStateBackingOff {
+		nextAttempt := p.nextAttempt
+		pd.NextAttempt = &nextAttempt
+	}
+	if p.state == PullerStateConnected {
+		pd.Upstream = redactURL(p.source.config.SourcePullURLs[p.upstream])
+		pd.Resolved = redactURL(p.resolved)
+	}
+	return pd
+}
+
+// cancelReason returns the reason of the upstream connection cancel
+func (p *puller) cancelReason() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.idled {
+		return errIdle
+	}
+	return errReconnectRequested
+}
+
+func (p *puller) wait(delay time.Duration) {
+	p.Lock()
+	p.state = PullerStateBackingOff
+	p.nextAttempt = time.Now().Add(delay)
+	p.Unlock()
+
+	logger.Noticef("SOURCE \"%s\": reconnecting to upstream in %s", p.source.config.Path, delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.wakeup:
+	}
+}
+
+func (p *puller) run() {
+	for {
+		if p.source.config.OnDemand {
+			p.waitDemand()
+		}
+		// dropping the possible reconnect request made while connected
+		select {
+		case <-p.wakeup:
+		default:
+		}
+
+		connected, err := p.pullRound()
+		p.disconnected(connected)
+		if err == errReconnectRequested {
+			logger.Noticef("SOURCE \"%s\": reconnecting to upstream on request", p.source.config.Path)
+			continue
+		}
+		if err == errIdle {
+			logger.Noticef("SOURCE \"%s\": no listeners for %s, disconnected from upstream",
+				p.source.config.Path, p.source.config.IdleTimeout)
+			continue
+		}
+		if err != nil {
+			p.fail(err)
+		}
+
+		delay := p.backoff()
+		if delay > 0 {
+			p.wait(delay)
+		}
+	}
+}
+
+// pullRound tries upstreams in priority order until one of them connects
+func (p *puller) pullRound() (time.Duration, error) {
+	var err error
+	upstreams := p.source.config.SourcePullURLs
+	for idx := range upstreams {
+		var connected time.Duration
+		p.Lock()
+		p.state = PullerStateConnecting
+		p.upstream = idx
+		p.Unlock()
+
+		connected, err = p.pull(idx)
+		p.source.active = false
+		p.resetPrimed()
+		if connected > 0 || err == errReconnectRequested || err == errIdle {
+			return connected, err
+		}
+		if idx < len(upstreams)-1 {
+			p.recordError(err)
+			logger.Noticef("SOURCE \"%s\": trying next upstream %s", p.source.config.Path, redactURL(upstreams[idx+1]))
+		}
+	}
+	return 0, err
+}
+
+// probe checks periodically if any of the upstreams with a higher priority
+// than the current one is available and reconnects if so
+func (p *puller) probe(ctx context.Context, current int) {
+	ticker := time.NewTicker(p.source.config.PullProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for idx, u := range p.source.config.SourcePullURLs[:current] {
+			if p.upstreamAvailable(ctx, u) {
+				logger.Noticef("SOURCE \"%s\": upstream %s is available again, failing back", p.source.config.Path, redactURL(u))
+				p.Reconnect()
+				return
+			}
+			logger.Debugf("SOURCE \"%s\": upstream #%d is still unavailable", p.source.config.Path, idx)
+		}
+	}
+}
+
+// upstreamAvailable returns true if upstream resolves to a stream the same
+// way the puller opens it and responds with some data. Probing the raw URL
+// would take a playlist with dead entries for an available upstream
+func (p *puller) upstreamAvailable(ctx context.Context, u *url.URL) bool {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	resp, err := p.open(ctx, u)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if hr, ok := resp.Body.(*hlsReader); ok {
+		// the first segment is already fetched, reading it would
+		// publish its metadata to the source
+		return len(hr.current.data) > 0
+	}
+	buf := make([]byte, 1)
+	n, _ := resp.Body.Read(buf)
+	return n > 0
+}
+
+// requestHeader returns the headers to be sent to upstream
+func (p *puller) requestHeader() http.Header {
+	hdr := make(http.Header)
+	for name, value := range p.source.config.PullHeaders {
+		hdr.Set(name, value)
+	}
+	userAgent := p.source.config.PullUserAgent
+	if userAgent == "" {
+		userAgent = "Flamecast/" + FlamecastVersion
+	}
+	hdr.Set("User-Agent", userAgent)
+	hdr.Set("Icy-MetaData", "1")
+	return hdr
+}
+
+// get requests the URL and checks the response status
+func (p *puller) get(ctx context.Context, u *url.URL) (*icyResponse, error) {
+	resp, err := icyGet(ctx, u, p.requestHeader())
+	if err != nil {
+		return nil, fmt.Errorf("error pulling source: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error pulling source: upstream responded with %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// open requests the upstream stream. If the upstream URL points to a
+// playlist, the playlist entries are tried in order. HLS playlists are
+// pulled segment by segment
+func (p *puller) open(ctx context.Context, u *url.URL) (*icyResponse, error) {
+	resp, err := p.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	format := playlist.Detect(resp.Header.Get("Content-Type"), resp.URL.Path)
+	if format == playlist.FormatNone {
+		return resp, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPlaylistSize))
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading playlist %s: %s", redactURL(resp.URL), err.Error())
+	}
+	if format == playlist.FormatM3U && playlist.IsHLS(data) {
+		return p.openHLS(ctx, resp.URL, data)
+	}
+	entries, err := playlist.Parse(format, data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing playlist %s: %s", redactURL(resp.URL), err.Error())
+	}
+
+	for _, entry := range entries {
+		var entryURL *url.URL
+		entryURL, err = resp.URL.Parse(entry)
+		if err != nil {
+			err = fmt.Errorf("invalid playlist entry %q: %s", entry, err.Error())
+			continue
+		}
+		var entryResp *icyResponse
+		entryResp, err = p.get(ctx, entryURL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			p.recordError(err)
+			continue
+		}
+		if playlist.Detect(entryResp.Header.Get("Content-Type"), entryResp.URL.Path) != playlist.FormatNone {
+			entryResp.Body.Close()
+			err = fmt.Errorf("nested playlist %s is not supported", redactURL(entryURL))
+			continue
+		}
+		logger.Noticef("SOURCE \"%s\": playlist %s resolved to %s", p.source.config.Path,
+			redactURL(resp.URL), redactURL(entryResp.URL))
+		return entryResp, nil
+	}
+	return nil, err
+}
+
+// pull connects to upstream and reads data into the source buffer until
+// an error occurs. It returns the time the puller has been streaming
+func (p *puller) pull(upstream int) (time.Duration, error) {
+	source := p.source
+	sourcePath := source.config.Path
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Lock()
+	p.cancel = cancel
+	p.idled = false
+	p.Unlock()
+	if source.config.OnDemand {
+		go p.watchIdle(ctx, cancel)
+	}
+
+	resp, err := p.open(ctx, source.config.SourcePullURLs[upstream])
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, p.cancelReason()
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	stats.PullerConnections++
+
+	source.setContentType(resp.Header.Get("Content-Type"))
+	readIceHeaders(source, resp.Header)
+	readIcyHeaders(source, resp.Header)
+
+	var metaInterval int64
+	miString := resp.Header.Get("icy-metaint")
+	if miString != "" {
+		metaInterval, _ = strconv.ParseInt(miString, 10, 64)
+	}
+
+	logger.Noticef("SOURCE \"%s\": source puller connected to %s", sourcePath, redactURL(resp.URL))
+	p.Lock()
+	p.state = PullerStateConnected
+	p.resolved = resp.URL
+	p.connections++
+	p.Unlock()
+	connectedAt := time.Now()
+
+	if upstream > 0 && source.config.PullProbeInterval > 0 {
+		go p.probe(ctx, upstream)
+	}
+
+	mfChannel := make(chan icy.MetaFrame, 1)
+	sr := watchStall(source, resp.Body, cancel)
+	defer sr.Stop()
+	reader := icy.NewReader(sr, int(metaInterval), mfChannel)
+	dataBuf := make([]byte, dataBufferSize)
+
+	iterations := 0
+
+	for {
+		n, err := reader.Read(dataBuf)
+		if err != nil {
+			if sr.Stalled() {
+				return time.Since(connectedAt), errStalled
+			}
+			if ctx.Err() != nil {
+				return time.Since(connectedAt), p.cancelReason()
+			}
+			return time.Since(connectedAt), fmt.Errorf("error reading data: %s", err.Error())
+		}
+		source.write(dataBuf[:n])
+		select {
+		case metaFrame := <-mfChannel:
+			meta, err := metaFrame.ParseMeta()
+			if err != nil {
+				logger.Errorf("SOURCE \"%s\": error parsing metadata: %s", sourcePath, err.Error())
+			} else {
+				source.currentMeta = meta
+				source.currentMetaFrame = &metaFrame
+				logger.Noticef("SOURCE \"%s\": got metadata %v", sourcePath, meta)
+			}
+		default:
+		}
+
+		if !source.active {
+			iterations++
+			if iterations == blocksWrittenUntilActive {
+				logger.Noticef("SOURCE \"%s\": source buffer filled, source is now active", sourcePath)
+				source.active = true
+				source.Started = time.Now()
+				p.signalPrimed()
+			}
+		}
+	}
+}
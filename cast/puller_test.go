@@ -0,0 +1,329 @@
+package cast
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/id3"
+)
+
+var testRetry = configreader.RetryConfig{
+	Initial: time.Second,
+	Max:     60 * time.Second,
+	Factor:  2,
+	Healthy: 30 * time.Second,
+}
+
+func TestRetryDelay(t *testing.T) {
+	cases := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		// capped by the max delay
+		{7, 60 * time.Second},
+		{20, 60 * time.Second},
+		{1000, 60 * time.Second},
+	}
+	for _, c := range cases {
+		delay := retryDelay(testRetry, c.failures)
+		if delay != c.delay {
+			t.Errorf("%d failures: expected %s, got %s", c.failures, c.delay, delay)
+		}
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	rc := testRetry
+	rc.Jitter = 0.2
+	for failures, base := range map[int]time.Duration{1: time.Second, 4: 8 * time.Second, 10: 60 * time.Second} {
+		for i := 0; i < 100; i++ {
+			delay := retryDelay(rc, failures)
+			if delay < base*8/10 || delay > base*12/10 {
+				t.Fatalf("%d failures: delay %s is out of %s +/- 20%%", failures, delay, base)
+			}
+		}
+	}
+}
+
+func TestPullerBackoffReset(t *testing.T) {
+	source := newTestPushSource()
+	source.config.Retry = testRetry
+	p := newPuller(source)
+
+	if delay := p.backoff(); delay != 0 {
+		t.Errorf("expected no delay before failures, got %s", delay)
+	}
+	for i := 0; i < 3; i++ {
+		p.fail(errors.New("connection refused"))
+	}
+	if delay := p.backoff(); delay != 4*time.Second {
+		t.Errorf("expected 4s delay after 3 failures, got %s", delay)
+	}
+
+	// a short connection doesn't reset the backoff
+	p.disconnected(time.Second)
+	p.fail(errors.New("connection reset"))
+	if delay := p.backoff(); delay != 8*time.Second {
+		t.Errorf("expected 8s delay after 4 failures, got %s", delay)
+	}
+
+	// a healthy connection does
+	p.disconnected(testRetry.Healthy)
+	if delay := p.backoff(); delay != 0 {
+		t.Errorf("expected no delay after a healthy connection, got %s", delay)
+	}
+	p.fail(errors.New("connection reset"))
+	if delay := p.backoff(); delay != time.Second {
+		t.Errorf("expected 1s delay after a failure, got %s", delay)
+	}
+}
+
+func TestPullerPrimed(t *testing.T) {
+	source := newTestPushSource()
+	p := newPuller(source)
+
+	if p.waitPrimed(10 * time.Millisecond) {
+		t.Error("inactive source is not expected to get primed")
+	}
+
+	// a listener waiting for the source is woken up as soon as it's primed
+	done := make(chan bool)
+	go func() {
+		done <- p.waitPrimed(5 * time.Second)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// a failed connection attempt keeps the waiters
+	p.resetPrimed()
+	p.signalPrimed()
+	select {
+	case primed := <-done:
+		if !primed {
+			t.Error("source is expected to get primed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listener is expected to be woken up")
+	}
+
+	// the listeners wait again after the source has stopped
+	p.resetPrimed()
+	if p.waitPrimed(10 * time.Millisecond) {
+		t.Error("stopped source is not expected to be primed")
+	}
+}
+
+func TestDemandChain(t *testing.T) {
+	var chain []*Source
+	for i := 0; i < 4; i++ {
+		source := newTestPushSource()
+		source.puller = newPuller(source)
+		chain = append(chain, source)
+	}
+	chain[0].config.OnDemand = true
+	chain[2].config.OnDemand = true
+	chain[3].config.OnDemand = true
+
+	demanded := func() []bool {
+		var result []bool
+		for _, source := range chain {
+			select {
+			case <-source.puller.demand:
+				result = append(result, true)
+			default:
+				result = append(result, false)
+			}
+		}
+		return result
+	}
+
+	cases := []struct {
+		level    int
+		expected []bool
+	}{
+		{0, []bool{false, false, false, false}},
+		{3, []bool{true, false, true, false}},
+		{-1, []bool{true, false, true, true}},
+	}
+	for _, c := range cases {
+		demandChain(chain, c.level)
+		result := demanded()
+		for i := range chain {
+			if result[i] != c.expected[i] {
+				t.Errorf("level %d: expected demanded %v, got %v", c.level, c.expected, result)
+				break
+			}
+		}
+	}
+}
+
+// serveTestUpstream serves an endless MP3 stream at /stream while up is set,
+// along with the playlists pointing to it and to a dead entry
+func serveTestUpstream(t *testing.T, up *int32) *httptest.Server {
+	frame := layer3Frame(t, 44100, 128, 2)
+	segment := append(id3.Render(id3.TextFrame("TIT2", "Segment")), bytes.Repeat(frame, 10)...)
+	hlsPlaylist := func(uri string) string {
+		return "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:0.26,\n" + uri + "\n#EXT-X-ENDLIST\n"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(up) == 0 {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Set("Content-Type", "audio/mpeg")
+		for {
+			if _, err := rw.Write(bytes.Repeat(frame, 10)); err != nil {
+				return
+			}
+			rw.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	})
+	mux.HandleFunc("/live.m3u", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "audio/x-mpegurl")
+		rw.Write([]byte("#EXTM3U\n/stream\n"))
+	})
+	mux.HandleFunc("/dead.m3u", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "audio/x-mpegurl")
+		rw.Write([]byte("#EXTM3U\n/missing\n"))
+	})
+	mux.HandleFunc("/live.m3u8", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		rw.Write([]byte(hlsPlaylist("segment.mp3")))
+	})
+	mux.HandleFunc("/dead.m3u8", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		rw.Write([]byte(hlsPlaylist("missing.mp3")))
+	})
+	mux.HandleFunc("/segment.mp3", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "audio/mpeg")
+		rw.Write(segment)
+	})
+	return httptest.NewServer(mux)
+}
+
+func testUpstreamURL(srv *httptest.Server, path string) *url.URL {
+	u, _ := url.Parse(srv.URL + path)
+	return u
+}
+
+func TestUpstreamAvailable(t *testing.T) {
+	up := int32(1)
+	srv := serveTestUpstream(t, &up)
+	defer srv.Close()
+	source := newTestPushSource()
+	p := newPuller(source)
+
+	cases := []struct {
+		path      string
+		available bool
+	}{
+		{"/stream", true},
+		{"/missing", false},
+		{"/live.m3u", true},
+		// a playlist is only available if one of its entries is
+		{"/dead.m3u", false},
+		{"/live.m3u8", true},
+		{"/dead.m3u8", false},
+	}
+	for _, c := range cases {
+		if available := p.upstreamAvailable(context.Background(), testUpstreamURL(srv, c.path)); available != c.available {
+			t.Errorf("%s: expected available %v, got %v", c.path, c.available, available)
+		}
+	}
+	if title := source.currentMeta["StreamTitle"]; title != "" {
+		t.Errorf("probing is not expected to publish metadata, got %q", title)
+	}
+
+	atomic.StoreInt32(&up, 0)
+	for _, path := range []string{"/stream", "/live.m3u"} {
+		if p.upstreamAvailable(context.Background(), testUpstreamURL(srv, path)) {
+			t.Errorf("%s: upstream is down and not expected to be available", path)
+		}
+	}
+}
+
+// waitUpstream waits for the puller to connect to the upstream
+func waitUpstream(t *testing.T, p *puller, upstream *url.URL) {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		pd := p.Desc()
+		if pd.State == PullerStateConnected && pd.Upstream == redactURL(upstream) {
+			return
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected puller connected to %s, got %s to %q", upstream, pd.State, pd.Upstream)
+		}
+	}
+}
+
+func TestPullerFailover(t *testing.T) {
+	primaryUp := int32(0)
+	primary := serveTestUpstream(t, &primaryUp)
+	defer primary.Close()
+	backupUp := int32(1)
+	backup := serveTestUpstream(t, &backupUp)
+	defer backup.Close()
+
+	source := newTestPushSource()
+	// the primary is a playlist which is only playable once its stream is up
+	primaryURL := testUpstreamURL(primary, "/live.m3u")
+	backupURL := testUpstreamURL(backup, "/stream")
+	source.config.SourcePullURLs = []*url.URL{primaryURL, backupURL}
+	source.config.PullProbeInterval = 20 * time.Millisecond
+	p := newPuller(source)
+	source.puller = p
+
+	pullRound := func() chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := p.pullRound()
+			done <- err
+		}()
+		return done
+	}
+	waitRound := func(done chan error) error {
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("pull round is expected to end")
+		}
+		return nil
+	}
+
+	// the primary is down, failing over to the backup
+	done := pullRound()
+	waitUpstream(t, p, backupURL)
+	// the primary playlist responds while its entry is down, the backup is kept
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("backup connection is not expected to drop, got %v", err)
+	default:
+	}
+
+	// the probe finds the primary available and makes the puller fail back
+	atomic.StoreInt32(&primaryUp, 1)
+	if err := waitRound(done); err != errReconnectRequested {
+		t.Fatalf("expected reconnect requested by the probe, got %v", err)
+	}
+	done = pullRound()
+	waitUpstream(t, p, primaryURL)
+	p.Reconnect()
+	if err := waitRound(done); err != errReconnectRequested {
+		t.Fatalf("expected reconnect requested, got %v", err)
+	}
+}
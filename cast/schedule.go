@@ -0,0 +1,103 @@
+package cast
+
+import (
+	"sync"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+)
+
+// scheduleCheckInterval is how often the schedule entries are matched
+// against the current time
+const scheduleCheckInterval = time.Second
+
+type (
+	// ScheduleDesc describes json representation of a schedule
+	ScheduleDesc struct {
+		Path   string    `json:"path"`
+		Name   string    `json:"name"`
+		Entry  string    `json:"entry"`
+		Source string    `json:"source"`
+		Since  time.Time `json:"since"`
+	}
+
+	// scheduler maps a public mount path to one of the sources according
+	// to the schedule entries. Listeners of the path follow the scheduled
+	// source switching at frame boundaries like they do moving to fallbacks
+	scheduler struct {
+		sync.Mutex
+		config *configreader.ScheduleConfig
+		entry  string
+		source *Source
+		since  time.Time
+	}
+)
+
+func newScheduler(sc *configreader.ScheduleConfig) *scheduler {
+	s := &scheduler{config: sc}
+	s.update(time.Now())
+	return s
+}
+
+// update selects the source of the first entry matching the time,
+// the default source if none matches
+func (s *scheduler) update(now time.Time) {
+	entry := ""
+	path := s.config.DefaultSourcePath
+	for _, e := range s.config.Entries {
+		if e.Expression.Match(now) {
+			entry = e.Name
+			path = e.SourcePath
+			break
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if entry == s.entry && !s.since.IsZero() {
+		return
+	}
+	s.entry = entry
+	s.source = sourcesPathMap[path]
+	s.since = now
+	switch {
+	case entry != "":
+		logger.Noticef("SCHEDULE \"%s\": entry %s is now active, source %s", s.config.Path, entry, path)
+	case s.source != nil:
+		logger.Noticef("SCHEDULE \"%s\": no entry is active, default source %s", s.config.Path, path)
+	default:
+		logger.Noticef("SCHEDULE \"%s\": no entry is active and there's no default source", s.config.Path)
+	}
+}
+
+// run matches the schedule entries forever
+func (s *scheduler) run() {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.update(now)
+	}
+}
+
+// current returns the scheduled source or nil if nothing is scheduled
+func (s *scheduler) current() *Source {
+	s.Lock()
+	defer s.Unlock()
+	return s.source
+}
+
+// Desc returns the schedule state description
+func (s *scheduler) Desc() ScheduleDesc {
+	s.Lock()
+	defer s.Unlock()
+	sd := ScheduleDesc{
+		Path:  s.config.Path,
+		Name:  s.config.Name,
+		Entry: s.entry,
+		Since: s.since,
+	}
+	if s.source != nil {
+		sd.Source = s.source.config.Path
+	}
+	return sd
+}
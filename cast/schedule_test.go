@@ -0,0 +1,126 @@
+package cast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/cron"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// newTestScheduler returns the scheduler of the /radio mount playing
+// /show on weekdays from 20:00 to 21:59 and /autodj otherwise
+func newTestScheduler(t *testing.T, now time.Time) *scheduler {
+	expr, err := cron.Parse("* 20-21 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched := &scheduler{config: &configreader.ScheduleConfig{
+		Name:              "radio",
+		Path:              "/radio",
+		DefaultSourcePath: "/autodj",
+		Entries: []*configreader.ScheduleEntry{
+			{Name: "show", When: "* 20-21 * * 1-5", Expression: expr, SourcePath: "/show"},
+		},
+	}}
+	sched.update(now)
+	schedulesPathMap["/radio"] = sched
+	return sched
+}
+
+func TestSchedulerUpdate(t *testing.T) {
+	withTestMounts(func() {
+		autodj := newTestMount(t, "/autodj", "audio/mpeg", 0x11)
+		show := newTestMount(t, "/show", "audio/mpeg", 0x22)
+		// 2026-10-16 is Friday
+		sched := newTestScheduler(t, at("2026-10-16 19:59"))
+
+		cases := []struct {
+			time   string
+			entry  string
+			source *Source
+		}{
+			{"2026-10-16 19:59", "", autodj},
+			{"2026-10-16 20:00", "show", show},
+			{"2026-10-16 21:59", "show", show},
+			{"2026-10-16 22:00", "", autodj},
+			{"2026-10-17 20:30", "", autodj},
+		}
+		for _, c := range cases {
+			sched.update(at(c.time))
+			sd := sched.Desc()
+			if sched.current() != c.source || sd.Entry != c.entry {
+				t.Errorf("%s: expected entry %q source %s, got %q source %s",
+					c.time, c.entry, c.source.config.Path, sd.Entry, sd.Source)
+			}
+		}
+
+		// the switch time is kept while the entry stays the same
+		sched.update(at("2026-10-16 20:00"))
+		sched.update(at("2026-10-16 20:30"))
+		if since := sched.Desc().Since; !since.Equal(at("2026-10-16 20:00")) {
+			t.Errorf("expected the show to be on since 20:00, got %s", since)
+		}
+
+		// no source at all if the default one is not defined
+		sched.config.DefaultSourcePath = ""
+		sched.update(at("2026-10-16 23:00"))
+		if source := sched.current(); source != nil {
+			t.Errorf("expected no source scheduled, got %s", source.config.Path)
+		}
+	})
+}
+
+func TestListenerSchedule(t *testing.T) {
+	withTestMounts(func() {
+		newTestMount(t, "/autodj", "audio/mpeg", 0x11)
+		newTestMount(t, "/show", "audio/mpeg", 0x22)
+		sched := newTestScheduler(t, at("2026-10-16 19:59"))
+
+		lt := serveTestListener("/radio")
+		if marker := lt.next(t); marker != 0x11 {
+			t.Fatalf("expected the default source data, got data marked %#x", marker)
+		}
+
+		// switching into the show window
+		sched.update(at("2026-10-16 20:00"))
+		lt.skipTo(t, 0x22)
+
+		// and out of it
+		sched.update(at("2026-10-16 22:00"))
+		lt.skipTo(t, 0x11)
+		lt.leave(t)
+	})
+}
+
+func TestListenerScheduleInactive(t *testing.T) {
+	withTestMounts(func() {
+		newTestMount(t, "/autodj", "audio/mpeg", 0x11)
+		show := newTestMount(t, "/show", "audio/mpeg", 0x22)
+		show.active = false
+		sched := newTestScheduler(t, at("2026-10-16 19:59"))
+
+		lt := serveTestListener("/radio")
+		if marker := lt.next(t); marker != 0x11 {
+			t.Fatalf("expected the default source data, got data marked %#x", marker)
+		}
+
+		// the listener stays on the default source until the show starts
+		sched.update(at("2026-10-16 20:00"))
+		lt.resume()
+		if marker := lt.next(t); marker != 0x11 {
+			t.Fatalf("expected the default source data, got data marked %#x", marker)
+		}
+		show.active = true
+		lt.skipTo(t, 0x22)
+		lt.leave(t)
+	})
+}
@@ -6,7 +6,9 @@ import (
 	"os"
 
 	logging "github.com/op/go-logging"
+	"github.com/viert/flamecast/aac"
 	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/mpeg"
 )
 
 const (
@@ -18,10 +20,11 @@ const (
 )
 
 var (
-	logger         *logging.Logger
-	config         *configreader.Config
-	sourcesPathMap = make(map[string]*Source)
-	stats          = new(StatsData)
+	logger           *logging.Logger
+	config           *configreader.Config
+	sourcesPathMap   = make(map[string]*Source)
+	schedulesPathMap = make(map[string]*scheduler)
+	stats            = new(StatsData)
 )
 
 func Configure(cfg *configreader.Config) error {
@@ -40,8 +43,22 @@ func Configure(cfg *configreader.Config) error {
 	stderrBackend.Color = true
 	logging.SetBackend(fileBackend, stderrBackend)
 
+	frameScanner = mpeg.NewScanner(config.SyncFrames, config.SyncCheckCRC)
+	adtsScanner = aac.NewScanner(config.SyncFrames)
+
 	for path, sourceConfig := range config.SourcesPathMap {
-		sourcesPathMap[path] = NewSource(sourceConfig)
+		source := NewSource(sourceConfig)
+		if sourceConfig.OfflineFile != "" || sourceConfig.OfflineSilence {
+			if err = checkOffline(sourceConfig); err != nil {
+				return err
+			}
+			source.offline = newOfflineSource(sourceConfig)
+		}
+		sourcesPathMap[path] = source
+	}
+
+	for path, scheduleConfig := range config.SchedulesPathMap {
+		schedulesPathMap[path] = newScheduler(scheduleConfig)
 	}
 
 	stats.SourcesCount = len(sourcesPathMap)
@@ -55,20 +72,54 @@ func Configure(cfg *configreader.Config) error {
 	return nil
 }
 
+// Stop stops the EXEC and FIFO sources. The source processes run in process
+// groups of their own and would outlive the server otherwise
+func Stop() {
+	for path, source := range sourcesPathMap {
+		if source.supervisor != nil {
+			logger.Noticef("Stopping supervisor of source %s", path)
+			source.supervisor.Stop()
+		}
+	}
+}
+
 // Start starts flamecast server
 func Start() *http.Server {
 	// Flamecast API
 	http.HandleFunc("/api/v1/stats", statsHandler)
+	http.HandleFunc("/api/v1/reconnect", reconnectHandler)
 	// Icecast compatibility API
 	http.HandleFunc("/admin/metadata", adminMetadataHandler)
+	// SHOUTcast v1 compatibility API
+	http.HandleFunc("/admin.cgi", shoutcastAdminHandler)
 	// Main handler for feeding and listening to sources
 	http.HandleFunc("/", sourceHandler)
 
 	for path, source := range sourcesPathMap {
-		if source.config.Type == configreader.SourceTypePull {
+		switch source.config.Type {
+		case configreader.SourceTypePull:
 			logger.Noticef("Starting pulling thread for source %s", path)
-			go pullSource(source)
+			go source.puller.run()
+		case configreader.SourceTypePlaylist:
+			logger.Noticef("Starting playlist thread for source %s", path)
+			go source.autodj.run()
+		case configreader.SourceTypeExec, configreader.SourceTypeFIFO:
+			logger.Noticef("Starting supervisor thread for source %s", path)
+			go source.supervisor.run()
 		}
+		if source.offline != nil {
+			logger.Noticef("Starting offline placeholder thread for source %s", path)
+			go source.offline.runOffline()
+		}
+	}
+
+	for path, sched := range schedulesPathMap {
+		logger.Noticef("Starting scheduler thread for schedule %s", path)
+		go sched.run()
+	}
+
+	if len(config.SourcesShoutcastMap) > 0 {
+		go startShoutcastListener(config.ShoutcastBind)
 	}
 
 	srv := &http.Server{Addr: config.Bind}
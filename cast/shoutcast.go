@@ -0,0 +1,140 @@
+package cast
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/viert/flamecast/icy"
+)
+
+const (
+	shoutcastHandshakeTimeout = 10 * time.Second
+	shoutcastPasswordMaxLen   = 1024
+)
+
+// startShoutcastListener accepts SHOUTcast v1 feeders on a dedicated port.
+// The protocol is not HTTP: a feeder sends a bare password line, waits for
+// "OK2", then sends icy-* headers followed by the raw audio data.
+func startShoutcastListener(bind string) {
+	ln, err := net.Listen("tcp", bind)
+	if err != nil {
+		logger.Errorf("error starting shoutcast source listener: %s", err.Error())
+		return
+	}
+	logger.Noticef("SHOUTcast source listener is starting on %s", bind)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			logger.Errorf("error accepting shoutcast feeder: %s", err.Error())
+			continue
+		}
+		go shoutcastSource(conn)
+	}
+}
+
+func shoutcastSource(conn net.Conn) {
+	defer conn.Close()
+	remoteAddr := conn.RemoteAddr().String()
+
+	conn.SetReadDeadline(time.Now().Add(shoutcastHandshakeTimeout))
+	rd := bufio.NewReader(conn)
+	tp := textproto.NewReader(rd)
+
+	password, err := tp.ReadLine()
+	if err != nil {
+		logger.Errorf("SHOUTcast feeder %s: error reading password: %s", remoteAddr, err.Error())
+		return
+	}
+	if len(password) > shoutcastPasswordMaxLen {
+		logger.Errorf("SHOUTcast feeder %s: password line is too long", remoteAddr)
+		return
+	}
+
+	source := shoutcastSourceByPassword(password)
+	if source == nil {
+		logger.Errorf("SHOUTcast feeder %s: authorization failed", remoteAddr)
+		conn.Write([]byte("invalid password\r\n"))
+		return
+	}
+	sourcePath := source.config.Path
+
+	f := newFeeder(remoteAddr, false, false)
+	if err := source.attachFeeder(f); err != nil {
+		logger.Errorf("SOURCE \"%s\": SHOUTcast feeder %s tried to feed already active source", sourcePath, remoteAddr)
+		conn.Write([]byte("Source is already streaming\r\n"))
+		return
+	}
+	defer source.detachFeeder(f)
+	// a takeover must not wait for the handshake to complete
+	f.onEvict(func() { conn.Close() })
+
+	_, err = conn.Write([]byte("OK2\r\nicy-caps:11\r\n\r\n"))
+	if err != nil {
+		return
+	}
+
+	hdr, err := tp.ReadMIMEHeader()
+	if err != nil {
+		logger.Errorf("SOURCE \"%s\": error reading SHOUTcast feeder headers: %s", sourcePath, err.Error())
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if source.feeders.streaming(f) {
+		// the hot-standby feeder is expected to send the same stream
+		source.setContentType(hdr.Get("Content-Type"))
+		readIcyHeaders(source, http.Header(hdr))
+	}
+	logger.Noticef("SOURCE \"%s\": SHOUTcast feeder %s accepted", sourcePath, remoteAddr)
+	stats.FeederConnections++
+
+	sr := watchStall(source, rd, func() { conn.Close() })
+	feedSource(source, sr, f)
+	sr.Stop()
+	if f.isEvicted() {
+		logger.Noticef("SOURCE \"%s\": SHOUTcast feeder %s has been disconnected by a takeover", sourcePath, remoteAddr)
+	} else {
+		logger.Noticef("SOURCE \"%s\": SHOUTcast feeder has disconnected", sourcePath)
+	}
+}
+
+func shoutcastSourceByPassword(password string) *Source {
+	// Some encoders send "password:#sid" in SHOUTcast v2 compatible mode
+	if idx := strings.Index(password, ":#"); idx >= 0 {
+		password = password[:idx]
+	}
+	scfg, found := config.SourcesShoutcastMap[password]
+	if !found {
+		return nil
+	}
+	return sourcesPathMap[scfg.Path]
+}
+
+// shoutcastAdminHandler implements SHOUTcast v1 metadata updates:
+// /admin.cgi?mode=updinfo&pass=<password>&song=<title>
+func shoutcastAdminHandler(rw http.ResponseWriter, req *http.Request) {
+	values := req.URL.Query()
+	source := shoutcastSourceByPassword(values.Get("pass"))
+	if source == nil {
+		http.Error(rw, "authorization failed", http.StatusUnauthorized)
+		return
+	}
+
+	if values.Get("mode") != "updinfo" {
+		http.Error(rw, "mode param is invalid", http.StatusBadRequest)
+		return
+	}
+	song := values.Get("song")
+	if song == "" {
+		http.Error(rw, "song param is missing", http.StatusBadRequest)
+		return
+	}
+
+	meta := icy.MetaData{"StreamTitle": song}
+	setSourceMetadata(source, meta)
+	rw.Write([]byte("metadata changed"))
+}
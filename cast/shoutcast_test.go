@@ -0,0 +1,185 @@
+package cast
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+)
+
+// newTestShoutcastSource registers a PUSH source accepting SHOUTcast
+// feeders with the given password. The returned function unregisters it
+func newTestShoutcastSource(password string) (*Source, func()) {
+	source := newTestPushSource()
+	source.config.ShoutcastPassword = password
+	sourcesPathMap[source.config.Path] = source
+	prevMap := config.SourcesShoutcastMap
+	config.SourcesShoutcastMap = map[string]*configreader.SourceConfig{password: source.config}
+	return source, func() {
+		config.SourcesShoutcastMap = prevMap
+		delete(sourcesPathMap, source.config.Path)
+	}
+}
+
+// waitDetached waits for the source to have no primary feeder
+func waitDetached(t *testing.T, source *Source) {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		source.feeders.Lock()
+		detached := source.feeders.primary == nil
+		source.feeders.Unlock()
+		if detached {
+			return
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("feeder is expected to be detached")
+		}
+	}
+}
+
+func TestShoutcastSource(t *testing.T) {
+	source, cleanup := newTestShoutcastSource("hackme")
+	defer cleanup()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go shoutcastSource(server)
+
+	// SHOUTcast v2 compatible encoders append the stream id
+	client.Write([]byte("hackme:#1\r\n"))
+	r := bufio.NewReader(client)
+	for _, expected := range []string{"OK2\r\n", "icy-caps:11\r\n", "\r\n"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading handshake response: %s", err)
+		}
+		if line != expected {
+			t.Fatalf("expected handshake line %q, got %q", expected, line)
+		}
+	}
+
+	client.Write([]byte("icy-name:Test Radio\r\n" +
+		"icy-genre:Jazz\r\n" +
+		"icy-pub:1\r\n" +
+		"icy-br:128\r\n" +
+		"content-type:audio/mpeg\r\n\r\n"))
+	frame := make([]byte, 417)
+	copy(frame, testFrames[:4])
+	client.Write(frame)
+	client.Close()
+	waitDetached(t, source)
+
+	stream := source.config.Stream
+	if stream.Name != "Test Radio" || stream.Genre != "Jazz" || !stream.Public {
+		t.Errorf("unexpected stream description %+v", stream)
+	}
+	if stream.Bitrate != 128 {
+		t.Errorf("expected bitrate 128, got %d", stream.Bitrate)
+	}
+	if source.Buffer.End() != uint64(len(frame)) {
+		t.Errorf("expected %d bytes in the source buffer, got %d", len(frame), source.Buffer.End())
+	}
+}
+
+func TestShoutcastSourceInvalidPassword(t *testing.T) {
+	_, cleanup := newTestShoutcastSource("hackme")
+	defer cleanup()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go shoutcastSource(server)
+
+	client.Write([]byte("wrong\r\n"))
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatalf("error reading handshake response: %s", err)
+	}
+	if line != "invalid password\r\n" {
+		t.Errorf("expected invalid password response, got %q", line)
+	}
+}
+
+func TestShoutcastAdminHandler(t *testing.T) {
+	source, cleanup := newTestShoutcastSource("hackme")
+	defer cleanup()
+
+	cases := []struct {
+		query  string
+		status int
+	}{
+		{"mode=updinfo&pass=wrong&song=Title", http.StatusUnauthorized},
+		{"mode=viewxml&pass=hackme&song=Title", http.StatusBadRequest},
+		{"mode=updinfo&pass=hackme", http.StatusBadRequest},
+		{"mode=updinfo&pass=hackme&song=Artist+-+Title", http.StatusOK},
+	}
+	for _, c := range cases {
+		rw := httptest.NewRecorder()
+		shoutcastAdminHandler(rw, httptest.NewRequest("GET", "/admin.cgi?"+c.query, nil))
+		if rw.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.query, c.status, rw.Code)
+		}
+	}
+	if title := source.currentMeta["StreamTitle"]; title != "Artist - Title" {
+		t.Errorf("expected StreamTitle \"Artist - Title\", got %q", title)
+	}
+}
+
+func TestShoutcastSourceTakeoverInHandshake(t *testing.T) {
+	source, cleanup := newTestShoutcastSource("hackme")
+	defer cleanup()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go shoutcastSource(server)
+
+	// the feeder is authorized but hasn't sent its headers yet
+	client.Write([]byte("hackme\r\n"))
+	if _, err := bufio.NewReader(client).ReadString('\n'); err != nil {
+		t.Fatalf("error reading handshake response: %s", err)
+	}
+
+	start := time.Now()
+	attachTestFeeder(t, source, newFeeder("override", true, false))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("takeover is expected to disconnect the feeder in handshake at once, took %s", elapsed)
+	}
+}
+
+func TestShoutcastSourceStandby(t *testing.T) {
+	source, cleanup := newTestShoutcastSource("hackme")
+	defer cleanup()
+
+	// the backup feeder is streaming while the primary is away
+	attachTestFeeder(t, source, newFeeder("backup", false, true))
+	source.setContentType("audio/aac")
+	feedStart := source.feedStart
+
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go shoutcastSource(server)
+
+	client.Write([]byte("hackme\r\n"))
+	r := bufio.NewReader(client)
+	for i := 0; i < 3; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatalf("error reading handshake response: %s", err)
+		}
+	}
+	client.Write([]byte("icy-name:Primary\r\ncontent-type:audio/mpeg\r\n\r\n"))
+	client.Close()
+	waitDetached(t, source)
+
+	if source.format != formatAAC || source.feedStart != feedStart {
+		t.Errorf("the reconnecting primary is expected to leave the backup stream alone, got %s", source.ContentType)
+	}
+	if source.config.Stream.Name == "Primary" {
+		t.Error("the reconnecting primary is expected to leave the stream description alone")
+	}
+}
@@ -1,26 +1,32 @@
 package cast
 
 import (
+	"bufio"
+	"io"
 	"net/http"
-	"strconv"
+	"net/http/httputil"
 	"strings"
 	"time"
 
 	"github.com/viert/endless"
 	"github.com/viert/flamecast/configreader"
 	"github.com/viert/flamecast/icy"
+	"github.com/viert/flamecast/ogg"
 )
 
 const (
 	blocksWrittenUntilActive = 4
 	dataBufferSize           = 4096
 	endlessSize              = 16 * 4096
-	pullRetriesMax           = 5
 )
 
 type (
 	// Source is the main source holder with configuration, buffers, metadata, listeners etc.
 	Source struct {
+		// accessed atomically, the first fields are 64-bit aligned on 32-bit platforms
+		stalls    uint64
+		takeovers uint64
+
 		config           *configreader.SourceConfig
 		Buffer           *endless.Endless
 		currentMeta      icy.MetaData
@@ -30,97 +36,44 @@ type (
 
 		Started     time.Time
 		ContentType string
+
+		format     int
+		ogg        *ogg.HeaderCache
+		analyzer   *streamAnalyzer
+		segmenter  *segmenter
+		puller     *puller
+		autodj     *autoDJ
+		supervisor *supervisor
+		feedStart  uint64
+		feeders    *feederSet
+		offline    *Source
 	}
 )
 
 // NewSource creates and initializes a new Source instance
 func NewSource(config *configreader.SourceConfig) *Source {
-	return &Source{
-		config,
-		endless.NewEndless(endlessSize),
-		make(icy.MetaData),
-		&icy.MetaFrame{0},
-		newListenerSlice(512),
-		false,
-		time.Now(),
-		"audio/mpeg",
+	source := &Source{
+		config:           config,
+		Buffer:           endless.NewEndless(endlessSize),
+		currentMeta:      make(icy.MetaData),
+		currentMetaFrame: &icy.MetaFrame{0},
+		listeners:        newListenerSlice(512),
+		Started:          time.Now(),
+		ContentType:      defaultContentType,
+		format:           formatMPEG,
+		analyzer:         newStreamAnalyzer(formatMPEG),
+		segmenter:        newSegmenter(),
+		feeders:          newFeederSet(config.BackupStallTime),
 	}
-}
-
-func pullSource(source *Source) {
-	retriesLeft := pullRetriesMax
-
-	sourceURL := source.config.SourcePullURL.String()
-	sourcePath := source.config.Path
-
-	cli := new(http.Client)
-
-retryLoop:
-	for retriesLeft > 0 {
-		req, err := http.NewRequest("GET", sourceURL, nil)
-		if err != nil {
-			logger.Errorf("SOURCE \"%s\": error creating request: %s", sourcePath, err.Error())
-			retriesLeft--
-			continue retryLoop
-		}
-
-		req.Header.Set("Icy-MetaData", "1")
-		resp, err := cli.Do(req)
-		if err != nil {
-			logger.Errorf("SOURCE \"%s\": error pulling source: %s", sourcePath, err.Error())
-			retriesLeft--
-			continue retryLoop
-		}
-		stats.PullerConnections++
-
-		readIceHeaders(source, resp.Header)
-
-		var metaInterval int64
-		miString := resp.Header.Get("icy-metaint")
-		if miString != "" {
-			metaInterval, _ = strconv.ParseInt(miString, 10, 64)
-		}
-
-		logger.Noticef("SOURCE \"%s\": source puller connected", sourcePath)
-
-		mfChannel := make(chan icy.MetaFrame, 1)
-		reader := icy.NewReader(resp.Body, int(metaInterval), mfChannel)
-		dataBuf := make([]byte, dataBufferSize)
-
-		iterations := 0
-
-		for {
-			n, err := reader.Read(dataBuf)
-			if err != nil {
-				logger.Errorf("SOURCE \"%s\": error reading data: %s", sourcePath, err.Error())
-				retriesLeft--
-				continue retryLoop
-			}
-			source.Buffer.Write(dataBuf[:n])
-			select {
-			case metaFrame := <-mfChannel:
-				meta, err := metaFrame.ParseMeta()
-				if err != nil {
-					logger.Errorf("SOURCE \"%s\": error parsing metadata: %s", sourcePath, err.Error())
-				} else {
-					source.currentMeta = meta
-					source.currentMetaFrame = &metaFrame
-					logger.Noticef("SOURCE \"%s\": got metadata %v", sourcePath, meta)
-				}
-			default:
-			}
-
-			if !source.active {
-				iterations++
-				if iterations == blocksWrittenUntilActive {
-					logger.Noticef("SOURCE \"%s\": source buffer filled, source is now active", sourcePath)
-					source.active = true
-					source.Started = time.Now()
-				}
-			}
-		}
+	switch config.Type {
+	case configreader.SourceTypePull:
+		source.puller = newPuller(source)
+	case configreader.SourceTypePlaylist:
+		source.autodj = newAutoDJ(source)
+	case configreader.SourceTypeExec, configreader.SourceTypeFIFO:
+		source.supervisor = newSupervisor(source)
 	}
-	source.active = false
+	return source
 }
 
 func pushSource(rw http.ResponseWriter, req *http.Request) {
@@ -132,20 +85,34 @@ func pushSource(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if source.active {
-		logger.Errorf("SOURCE \"%s\": tried to feed already active source", sourcePath)
-		http.Error(rw, "Source is already streaming", http.StatusConflict)
+	if source.config.Type != configreader.SourceTypePush {
+		logger.Errorf("SOURCE \"%s\": tried to feed a source which is not of PUSH type", sourcePath)
+		http.Error(rw, "Source is not of PUSH type", http.StatusForbidden)
 		return
 	}
 
-	if !checkSourceAuth(source, req) {
+	override := checkOverrideAuth(source, req)
+	backup := !override && checkBackupAuth(source, req)
+	if !override && !backup && !checkSourceAuth(source, req) {
 		logger.Errorf("SOURCE \"%s\": Feeder authorization failed", sourcePath)
 		http.Error(rw, "Source authorization failed", http.StatusUnauthorized)
 		return
 	}
 
-	readIceHeaders(source, req.Header)
-	logger.Noticef("SOURCE \"%s\": feeder accepted", sourcePath)
+	f := newFeeder(req.RemoteAddr, override, backup)
+	if err := source.attachFeeder(f); err != nil {
+		logger.Errorf("SOURCE \"%s\": %s feeder tried to feed already active source", sourcePath, f.role())
+		http.Error(rw, "Source is already streaming", http.StatusConflict)
+		return
+	}
+	defer source.detachFeeder(f)
+
+	if source.feeders.streaming(f) {
+		// the hot-standby feeder is expected to send the same stream
+		source.setContentType(req.Header.Get("Content-Type"))
+		readIceHeaders(source, req.Header)
+	}
+	logger.Noticef("SOURCE \"%s\": %s feeder accepted", sourcePath, f.role())
 	stats.FeederConnections++
 
 	hj, ok := rw.(http.Hijacker)
@@ -161,24 +128,82 @@ func pushSource(rw http.ResponseWriter, req *http.Request) {
 	}
 	defer conn.Close()
 
+	// Icecast 2.4 compatible PUT feeders (ffmpeg, liquidsoap, libshout 2.4+)
+	// may wait for the interim response before sending any data
+	if strings.ToLower(req.Header.Get("Expect")) == "100-continue" {
+		bufrw.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+	}
 	bufrw.WriteString("HTTP/1.0 200 OK\r\n\r\n")
 	bufrw.Flush()
 
+	f.onEvict(func() { conn.Close() })
+	sr := watchStall(source, feederBody(req, bufrw.Reader), func() { conn.Close() })
+	feedSource(source, sr, f)
+	sr.Stop()
+	if f.isEvicted() {
+		logger.Noticef("SOURCE \"%s\": %s feeder %s has been disconnected by a takeover", sourcePath, f.role(), f.remoteAddr)
+	} else {
+		logger.Noticef("SOURCE \"%s\": %s feeder has disconnected", sourcePath, f.role())
+	}
+}
+
+// feederBody wraps the hijacked connection reader according to the
+// request transfer encoding so that the source gets the raw stream data
+func feederBody(req *http.Request, r *bufio.Reader) io.Reader {
+	for _, te := range req.TransferEncoding {
+		if te == "chunked" {
+			return httputil.NewChunkedReader(r)
+		}
+	}
+	if req.ContentLength > 0 {
+		return io.LimitReader(r, req.ContentLength)
+	}
+	return r
+}
+
+// feedSource reads the feeder data into the source buffer until
+// the reader returns an error. Only complete frames are written and
+// the data is paced to real time if the source is configured so.
+// f is the PUSH source feeder or nil for the other source types
+func feedSource(source *Source, r io.Reader, f *feeder) {
 	iterations := 0
 	dataBuf := make([]byte, dataBufferSize)
 
+	var pc *pacer
+	if source.config.Pacing {
+		pc = newPacer(source)
+		// the primary and the backup feeders are paced separately
+		source.feeders.setPacer(f, pc)
+		if f == nil {
+			defer source.feeders.setPacer(nil, nil)
+		}
+	}
+	aligner := &frameAligner{format: source.format}
+
 	for {
-		n, err := bufrw.Read(dataBuf)
+		discarded := false
+		n, err := r.Read(dataBuf)
+		if n > 0 {
+			frames, duration := aligner.next(dataBuf[:n])
+			if len(frames) > 0 {
+				if f == nil {
+					source.write(frames)
+				} else if !source.writeFeeder(f, frames) {
+					// the hot-standby feeder data is discarded
+					discarded = true
+				}
+			}
+			if pc != nil {
+				pc.hold(duration)
+			}
+		}
 		if err != nil {
-			logger.Noticef("SOURCE \"%s\": feeder has disconnected", sourcePath)
-			source.active = false
 			break
 		}
-		source.Buffer.Write(dataBuf[:n])
-		if !source.active {
+		if !source.active && !discarded {
 			iterations++
 			if iterations == blocksWrittenUntilActive {
-				logger.Noticef("SOURCE \"%s\": source buffer filled, source is now active", sourcePath)
+				logger.Noticef("SOURCE \"%s\": source buffer filled, source is now active", source.config.Path)
 				source.active = true
 				source.Started = time.Now()
 			}
@@ -188,13 +213,19 @@ func pushSource(rw http.ResponseWriter, req *http.Request) {
 
 func sourceHandler(rw http.ResponseWriter, req *http.Request) {
 	switch req.Method {
-	// case "PUT":
-	// 	fallthrough
-	case "SOURCE":
+	case "PUT", "SOURCE":
 		pushSource(rw, req)
 
 	case "GET":
-		handleListener(rw, req)
+		if source, name, found := hlsTarget(req.URL.Path); found {
+			handleHLS(rw, req, source, name)
+		} else {
+			handleListener(rw, req)
+		}
+
+	default:
+		rw.Header().Set("Allow", "GET, PUT, SOURCE")
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -210,29 +241,95 @@ func checkSourceAuth(s *Source, req *http.Request) bool {
 	return token == "Basic "+s.config.SourceAuthToken
 }
 
+// checkOverrideAuth returns true if the feeder presents the override
+// credentials allowing it to take over the source
+func checkOverrideAuth(s *Source, req *http.Request) bool {
+	if s.config.OverrideAuthToken == "" {
+		return false
+	}
+	token := req.Header.Get("Authorization")
+	return token == "Basic "+s.config.OverrideAuthToken
+}
+
+// checkBackupAuth returns true if the feeder presents the hot-standby
+// backup feeder credentials
+func checkBackupAuth(s *Source, req *http.Request) bool {
+	if s.config.BackupAuthToken == "" {
+		return false
+	}
+	token := req.Header.Get("Authorization")
+	return token == "Basic "+s.config.BackupAuthToken
+}
+
+// streamHeaders names the feeder request headers carrying the stream
+// properties, an empty name means the protocol has no such header
+type streamHeaders struct {
+	name        string
+	description string
+	genre       string
+	url         string
+	public      string
+	bitrate     string
+	sampleRate  string
+	audioInfo   string
+}
+
+var (
+	// Icecast-style headers sent by libshout compatible feeders
+	iceHeaders = streamHeaders{
+		name:        "Ice-Name",
+		description: "Ice-Description",
+		genre:       "Ice-Genre",
+		url:         "Ice-Url",
+		public:      "Ice-Public",
+		bitrate:     "Ice-Bitrate",
+		audioInfo:   "Ice-Audio-Info",
+	}
+	// SHOUTcast-style headers
+	icyHeaders = streamHeaders{
+		name:        "Icy-Name",
+		description: "Icy-Description",
+		genre:       "Icy-Genre",
+		url:         "Icy-Url",
+		public:      "Icy-Pub",
+		bitrate:     "Icy-Br",
+		sampleRate:  "Icy-Sr",
+	}
+)
+
 func readIceHeaders(s *Source, hdr http.Header) {
-	name := hdr.Get("Ice-Name")
+	readStreamHeaders(s, hdr, &iceHeaders)
+}
+
+// readIcyHeaders reads stream properties from SHOUTcast-style icy-* headers
+func readIcyHeaders(s *Source, hdr http.Header) {
+	readStreamHeaders(s, hdr, &icyHeaders)
+}
+
+func readStreamHeaders(s *Source, hdr http.Header, names *streamHeaders) {
+	// http.Header.Get returns "" for an empty key
+	name := hdr.Get(names.name)
 	if name != "" {
 		s.config.Name = name
 		s.config.Stream.Name = name
 	}
 
-	description := hdr.Get("Ice-Description")
+	description := hdr.Get(names.description)
 	if description != "" {
 		s.config.Stream.Description = description
 	}
 
-	genre := hdr.Get("Ice-Genre")
+	genre := hdr.Get(names.genre)
 	if genre != "" {
 		s.config.Stream.Genre = genre
 	}
 
-	url := hdr.Get("Ice-Url")
+	url := hdr.Get(names.url)
 	if url != "" {
 		s.config.Stream.URL = url
 	}
 
-	public := hdr.Get("Ice-Public")
+	public := hdr.Get(names.public)
 	if public != "" {
 		public = strings.ToLower(public)
 		if public == "0" || public == "false" || public == "no" {
@@ -241,4 +338,19 @@ func readIceHeaders(s *Source, hdr http.Header) {
 			s.config.Stream.Public = true
 		}
 	}
+
+	bitrate := hdr.Get(names.bitrate)
+	if bitrate != "" {
+		s.config.Stream.ParseAudioInfo(configreader.AudioInfoBitrate + "=" + bitrate)
+	}
+
+	sampleRate := hdr.Get(names.sampleRate)
+	if sampleRate != "" {
+		s.config.Stream.ParseAudioInfo(configreader.AudioInfoSampleRate + "=" + sampleRate)
+	}
+
+	audioInfo := hdr.Get(names.audioInfo)
+	if audioInfo != "" {
+		s.config.Stream.ParseAudioInfo(audioInfo)
+	}
 }
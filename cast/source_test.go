@@ -0,0 +1,172 @@
+package cast
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeederBody(t *testing.T) {
+	cases := []struct {
+		name     string
+		req      *http.Request
+		data     string
+		expected string
+	}{
+		{
+			"chunked",
+			&http.Request{TransferEncoding: []string{"chunked"}, ContentLength: -1},
+			"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+			"hello world",
+		},
+		{
+			"content length",
+			&http.Request{ContentLength: 5},
+			"hello world",
+			"hello",
+		},
+		{
+			"raw",
+			&http.Request{ContentLength: 0},
+			"hello world",
+			"hello world",
+		},
+	}
+	for _, c := range cases {
+		body := feederBody(c.req, bufio.NewReader(strings.NewReader(c.data)))
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+			continue
+		}
+		if string(data) != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, data)
+		}
+	}
+}
+
+func TestSourceHandlerMethodNotAllowed(t *testing.T) {
+	rw := httptest.NewRecorder()
+	sourceHandler(rw, httptest.NewRequest("DELETE", "/test", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rw.Code)
+	}
+	if allow := rw.Header().Get("Allow"); allow != "GET, PUT, SOURCE" {
+		t.Errorf("expected Allow header \"GET, PUT, SOURCE\", got %q", allow)
+	}
+}
+
+func TestPushSourceContinue(t *testing.T) {
+	source := newTestPushSource()
+	source.config.SourceAuthToken = "c291cmNlOmhhY2ttZQ=="
+	sourcesPathMap[source.config.Path] = source
+	defer delete(sourcesPathMap, source.config.Path)
+
+	srv := httptest.NewServer(http.HandlerFunc(sourceHandler))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("PUT /test HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Authorization: Basic " + source.config.SourceAuthToken + "\r\n" +
+		"Content-Type: audio/mpeg\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Expect: 100-continue\r\n\r\n"))
+
+	r := bufio.NewReader(conn)
+	for _, expected := range []string{"HTTP/1.1 100 Continue", "", "HTTP/1.0 200 OK", ""} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading response: %s", err)
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != expected {
+			t.Fatalf("expected response line %q, got %q", expected, line)
+		}
+	}
+
+	// a chunked body with two complete MPEG frames
+	frame := make([]byte, 417)
+	copy(frame, testFrames[:4])
+	chunk := append(frame, frame...)
+	conn.Write([]byte("342\r\n"))
+	conn.Write(chunk)
+	conn.Write([]byte("\r\n0\r\n\r\n"))
+
+	// the feeder is detached once the body is over
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		source.feeders.Lock()
+		detached := source.feeders.primary == nil
+		source.feeders.Unlock()
+		if detached {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("feeder is expected to be detached at the end of the body")
+		}
+	}
+	if source.Buffer.End() != uint64(len(chunk)) {
+		t.Errorf("expected %d bytes in the source buffer, got %d", len(chunk), source.Buffer.End())
+	}
+}
+
+func TestPushSourceUnauthorized(t *testing.T) {
+	source := newTestPushSource()
+	source.config.SourceAuthToken = "c291cmNlOmhhY2ttZQ=="
+	sourcesPathMap[source.config.Path] = source
+	defer delete(sourcesPathMap, source.config.Path)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/test", nil)
+	req.Header.Set("Authorization", "Basic d3Jvbmc=")
+	sourceHandler(rw, req)
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rw.Code)
+	}
+}
+
+func TestSetContentTypeConfigured(t *testing.T) {
+	source := newTestPushSource()
+	source.config.ContentType = "audio/aac"
+	source.setContentType("")
+	if source.format != formatAAC || source.ContentType != "audio/aac" {
+		t.Errorf("expected the configured AAC stream, got %s", source.ContentType)
+	}
+	source.setContentType("application/ogg")
+	if source.format != formatOgg {
+		t.Errorf("expected the feeder content type to override the configured one, got %s", source.ContentType)
+	}
+}
+
+func TestNewReaderOggFeedStart(t *testing.T) {
+	source := newTestPushSource()
+	source.write(bytes.Repeat([]byte{0xAA}, 1000))
+
+	// the new feeder hasn't sent all of its header pages yet
+	source.setContentType("application/ogg")
+	data := []byte("OggS\x00\x02")
+	source.write(data)
+	reader, prefix := source.newReader()
+	if prefix != nil {
+		t.Errorf("expected no cached headers, got %d bytes", len(prefix))
+	}
+	buf := make([]byte, 100)
+	n, err := reader.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], data) {
+		t.Errorf("expected the new feeder data only, got %q", buf[:n])
+	}
+}
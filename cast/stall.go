@@ -0,0 +1,65 @@
+package cast
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var errStalled = errors.New("no data received within the source timeout")
+
+// stallReader watches the feeder (or upstream) reads and drops it if no
+// data arrives within the source timeout. Unless there's a hot-standby
+// feeder, the source is marked inactive at once so that listeners move
+// to the fallback source
+type stallReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+	stalled int32
+}
+
+// watchStall wraps the reader with a stall watchdog. stop is called to
+// break the blocked read when the source stalls
+func watchStall(source *Source, r io.Reader, stop func()) *stallReader {
+	sr := &stallReader{r: r, timeout: source.config.Timeout}
+	if sr.timeout == 0 {
+		return sr
+	}
+	sr.timer = time.AfterFunc(sr.timeout, func() {
+		atomic.StoreInt32(&sr.stalled, 1)
+		logger.Errorf("SOURCE \"%s\": no data for %s, dropping the connection", source.config.Path, sr.timeout)
+		stats.SourceStalls++
+		atomic.AddUint64(&source.stalls, 1)
+		if !source.feeders.standby() {
+			source.active = false
+		}
+		stop()
+	})
+	return sr
+}
+
+// Read implements io.Reader
+func (sr *stallReader) Read(buf []byte) (int, error) {
+	n, err := sr.r.Read(buf)
+	if n > 0 && sr.timer != nil {
+		sr.timer.Reset(sr.timeout)
+	}
+	if err != nil && sr.Stalled() {
+		err = errStalled
+	}
+	return n, err
+}
+
+// Stalled returns true if the watchdog has fired
+func (sr *stallReader) Stalled() bool {
+	return atomic.LoadInt32(&sr.stalled) == 1
+}
+
+// Stop stops the watchdog
+func (sr *stallReader) Stop() {
+	if sr.timer != nil {
+		sr.timer.Stop()
+	}
+}
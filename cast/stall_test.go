@@ -0,0 +1,83 @@
+package cast
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stallTest reads from a pipe nobody writes to until the watchdog fires
+func stallTest(t *testing.T, s *Source) {
+	s.config.Timeout = testStallTime
+	s.active = true
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	sr := watchStall(s, pr, func() { pr.CloseWithError(io.ErrClosedPipe) })
+	defer sr.Stop()
+	done := make(chan error)
+	go func() {
+		_, err := sr.Read(make([]byte, dataBufferSize))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != errStalled {
+			t.Errorf("expected errStalled, got %v", err)
+		}
+	case <-time.After(20 * testStallTime):
+		t.Fatal("stalled reader is expected to be closed")
+	}
+	if !sr.Stalled() {
+		t.Error("reader is expected to be marked stalled")
+	}
+	if stalls := atomic.LoadUint64(&s.stalls); stalls != 1 {
+		t.Errorf("expected 1 stall, got %d", stalls)
+	}
+}
+
+func TestWatchStall(t *testing.T) {
+	s := newTestPushSource()
+	attachTestFeeder(t, s, newFeeder("primary", false, false))
+	stallTest(t, s)
+	if s.active {
+		t.Error("source is expected to be inactive after the stall")
+	}
+}
+
+func TestWatchStallStandby(t *testing.T) {
+	s := newTestPushSource()
+	attachTestFeeder(t, s, newFeeder("primary", false, false))
+	attachTestFeeder(t, s, newFeeder("backup", false, true))
+	stallTest(t, s)
+	if !s.active {
+		t.Error("source is expected to stay active with a standby feeder")
+	}
+}
+
+func TestWatchStallData(t *testing.T) {
+	s := newTestPushSource()
+	s.config.Timeout = testStallTime
+	pr, pw := io.Pipe()
+	sr := watchStall(s, pr, func() { pr.CloseWithError(io.ErrClosedPipe) })
+
+	// the data keeps the watchdog from firing
+	go func() {
+		for i := 0; i < 10; i++ {
+			pw.Write(testFrames)
+			time.Sleep(testStallTime / 5)
+		}
+		pw.Close()
+	}()
+	buf := make([]byte, dataBufferSize)
+	var err error
+	for err == nil {
+		_, err = sr.Read(buf)
+	}
+	sr.Stop()
+	if err != io.EOF || sr.Stalled() {
+		t.Errorf("expected EOF with no stall, got %v", err)
+	}
+}
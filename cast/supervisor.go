@@ -0,0 +1,377 @@
+package cast
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/icy"
+)
+
+// Supervisor states
+const (
+	SupervisorStateStarting   = "starting"
+	SupervisorStateRunning    = "running"
+	SupervisorStateBackingOff = "backing_off"
+)
+
+// the delay before reopening the metadata pipe after an error
+const metadataReopenDelay = 5 * time.Second
+
+type (
+	// SupervisorDesc describes json representation of an EXEC or FIFO source state
+	SupervisorDesc struct {
+		State       string     `json:"state"`
+		Pid         int        `json:"pid,omitempty"`
+		LastError   string     `json:"last_error"`
+		LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+		NextAttempt *time.Time `json:"next_attempt,omitempty"`
+		Failures    int        `json:"failures"`
+		Starts      uint64     `json:"starts"`
+	}
+
+	// supervisor keeps an EXEC source process running (or a FIFO source
+	// pipe open) forever, restarting it with exponential backoff
+	supervisor struct {
+		sync.Mutex
+		source      *Source
+		state       string
+		pid         int
+		lastError   error
+		lastErrorAt time.Time
+		nextAttempt time.Time
+		failures    int
+		starts      uint64
+		kill        func()
+		wakeup      chan struct{}
+		stop        chan struct{}
+		stopOnce    sync.Once
+	}
+)
+
+func newSupervisor(source *Source) *supervisor {
+	return &supervisor{
+		source: source,
+		state:  SupervisorStateStarting,
+		wakeup: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Desc returns the supervisor state description
+func (sv *supervisor) Desc() *SupervisorDesc {
+	sv.Lock()
+	defer sv.Unlock()
+	sd := &SupervisorDesc{
+		State:    sv.state,
+		Pid:      sv.pid,
+		Failures: sv.failures,
+		Starts:   sv.starts,
+	}
+	if sv.lastError != nil {
+		sd.LastError = sv.lastError.Error()
+		errorAt := sv.lastErrorAt
+		sd.LastErrorAt = &errorAt
+	}
+	if sv.state == SupervisorStateBackingOff {
+		nextAttempt := sv.nextAttempt
+		sd.NextAttempt = &nextAttempt
+	}
+	return sd
+}
+
+func (sv *supervisor) fail(err error) {
+	sv.Lock()
+	defer sv.Unlock()
+	sv.lastError = err
+	sv.lastErrorAt = time.Now()
+	sv.failures++
+	logger.Errorf("SOURCE \"%s\": %s", sv.source.config.Path, err.Error())
+}
+
+// setKill sets the function breaking the current run of the process
+// (or the pipe reading), nil when there's nothing to break
+func (sv *supervisor) setKill(kill func()) {
+	sv.Lock()
+	defer sv.Unlock()
+	sv.kill = kill
+}
+
+// Restart kills the current process (or closes the pipe) if any and makes
+// the supervisor start over immediately
+func (sv *supervisor) Restart() {
+	sv.Lock()
+	sv.failures = 0
+	if sv.kill != nil {
+		sv.kill()
+	}
+	sv.Unlock()
+	select {
+	case sv.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Stop kills the current process (or closes the pipe) and makes the
+// supervisor and the metadata pipe reader exit. Opening a pipe can't be
+// interrupted, a pipe reader waiting for a writer exits once it shows up
+func (sv *supervisor) Stop() {
+	sv.stopOnce.Do(func() { close(sv.stop) })
+	sv.Lock()
+	defer sv.Unlock()
+	if sv.kill != nil {
+		sv.kill()
+	}
+}
+
+func (sv *supervisor) stopped() bool {
+	select {
+	case <-sv.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the delay unless a restart is requested. It returns
+// false if the supervisor is stopped
+func (sv *supervisor) wait(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-sv.wakeup:
+	case <-sv.stop:
+		return false
+	}
+	return true
+}
+
+func (sv *supervisor) run() {
+	source := sv.source
+	if source.config.MetadataFIFOPath != "" {
+		go sv.readMetadataFIFO()
+	}
+
+	for !sv.stopped() {
+		sv.Lock()
+		sv.state = SupervisorStateStarting
+		sv.Unlock()
+		// dropping the possible restart request made while running
+		select {
+		case <-sv.wakeup:
+		default:
+		}
+
+		startedAt := time.Now()
+		var err error
+		if source.config.Type == configreader.SourceTypeExec {
+			err = sv.runProcess()
+		} else {
+			err = sv.readFIFO()
+		}
+		source.active = false
+
+		sv.Lock()
+		sv.pid = 0
+		if time.Since(startedAt) >= source.config.Retry.Healthy {
+			sv.failures = 0
+		}
+		sv.Unlock()
+		if err != nil {
+			sv.fail(err)
+		}
+
+		sv.Lock()
+		delay := retryDelay(source.config.Retry, sv.failures)
+		sv.state = SupervisorStateBackingOff
+		sv.nextAttempt = time.Now().Add(delay)
+		sv.Unlock()
+		if sv.stopped() {
+			break
+		}
+		if delay > 0 {
+			logger.Noticef("SOURCE \"%s\": restarting in %s", source.config.Path, delay)
+			if !sv.wait(delay) {
+				break
+			}
+		}
+	}
+	logger.Noticef("SOURCE \"%s\": supervisor has stopped", source.config.Path)
+}
+
+// runProcess starts the source command and feeds its stdout to the source
+// until the process exits. An exit is always an error as the process is
+// expected to stream forever
+func (sv *supervisor) runProcess() error {
+	source := sv.source
+	sourcePath := source.config.Path
+
+	cmd := exec.Command("/bin/sh", "-c", source.config.ExecCommand)
+	// the shell and its pipeline children get a process group of their
+	// own so that all of them can be killed at once
+	setProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error creating stdout pipe: %s", err.Error())
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("error creating stderr pipe: %s", err.Error())
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting process: %s", err.Error())
+	}
+
+	pid := cmd.Process.Pid
+	logger.Noticef("SOURCE \"%s\": process %d started", sourcePath, pid)
+	sv.Lock()
+	sv.state = SupervisorStateRunning
+	sv.pid = pid
+	sv.starts++
+	sv.Unlock()
+
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Noticef("SOURCE \"%s\": [%d] %s", sourcePath, pid, scanner.Text())
+		}
+	}()
+
+	kill := func() {
+		killProcessGroup(cmd)
+		stdout.Close()
+	}
+	sv.setKill(kill)
+	if sv.stopped() {
+		// stopped while starting
+		kill()
+	}
+
+	source.setContentType(source.config.ContentType)
+	sr := watchStall(source, stdout, kill)
+	feedSource(source, sr, nil)
+	sr.Stop()
+	sv.setKill(nil)
+	// the stream is over, children left running may hold stderr open
+	killProcessGroup(cmd)
+	<-logged
+
+	err = cmd.Wait()
+	if sr.Stalled() {
+		return fmt.Errorf("process %d killed: %s", pid, errStalled.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("process %d exited: %s", pid, err.Error())
+	}
+	return fmt.Errorf("process %d exited", pid)
+}
+
+// readFIFO opens the named pipe and feeds the data to the source until
+// the writer closes the pipe
+func (sv *supervisor) readFIFO() error {
+	source := sv.source
+	sourcePath := source.config.Path
+
+	if err := checkNamedPipe(source.config.FIFOPath); err != nil {
+		return err
+	}
+	// opening a pipe blocks until a writer shows up
+	pipe, err := os.Open(source.config.FIFOPath)
+	if err != nil {
+		return fmt.Errorf("error opening pipe: %s", err.Error())
+	}
+	defer pipe.Close()
+
+	logger.Noticef("SOURCE \"%s\": pipe %s opened", sourcePath, source.config.FIFOPath)
+	sv.Lock()
+	sv.state = SupervisorStateRunning
+	sv.starts++
+	sv.Unlock()
+
+	kill := func() { pipe.Close() }
+	sv.setKill(kill)
+	if sv.stopped() {
+		kill()
+	}
+
+	source.setContentType(source.config.ContentType)
+	sr := watchStall(source, pipe, kill)
+	feedSource(source, sr, nil)
+	sr.Stop()
+	sv.setKill(nil)
+	if sr.Stalled() {
+		return errStalled
+	}
+	logger.Noticef("SOURCE \"%s\": pipe writer has disconnected", sourcePath)
+	return nil
+}
+
+// checkNamedPipe makes sure the path is a named pipe as reading a regular
+// file would end immediately over and over
+func checkNamedPipe(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("%s is not a named pipe", path)
+	}
+	return nil
+}
+
+// readMetadataFIFO reads the metadata pipe until the supervisor is stopped.
+// Every line is either a new StreamTitle or a "key=value" pair like
+// StreamUrl=http://example.com
+func (sv *supervisor) readMetadataFIFO() {
+	source := sv.source
+	sourcePath := source.config.Path
+	for !sv.stopped() {
+		err := checkNamedPipe(source.config.MetadataFIFOPath)
+		var pipe *os.File
+		if err == nil {
+			pipe, err = os.Open(source.config.MetadataFIFOPath)
+		}
+		if err != nil {
+			logger.Errorf("SOURCE \"%s\": error opening metadata pipe: %s", sourcePath, err.Error())
+			timer := time.NewTimer(metadataReopenDelay)
+			select {
+			case <-timer.C:
+			case <-sv.stop:
+				timer.Stop()
+			}
+			continue
+		}
+		readMetadataLines(source, pipe)
+		pipe.Close()
+	}
+}
+
+func readMetadataLines(source *Source, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		meta := make(icy.MetaData)
+		for key, value := range source.currentMeta {
+			meta[key] = value
+		}
+		if strings.HasPrefix(line, "StreamTitle=") || strings.HasPrefix(line, "StreamUrl=") {
+			tokens := strings.SplitN(line, "=", 2)
+			meta[tokens[0]] = tokens[1]
+		} else {
+			meta["StreamTitle"] = line
+		}
+		setSourceMetadata(source, meta)
+	}
+}
@@ -0,0 +1,192 @@
+//go:build !windows
+// +build !windows
+
+package cast
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/viert/flamecast/configreader"
+	"github.com/viert/flamecast/icy"
+)
+
+func newTestSupervisor(sourceType int) *supervisor {
+	source := newTestPushSource()
+	source.config.Type = sourceType
+	source.config.Retry = configreader.RetryConfig{Initial: time.Hour, Max: time.Hour, Factor: 2, Healthy: time.Hour}
+	return newSupervisor(source)
+}
+
+// runSupervisor starts the supervisor and returns the channel closed when it exits
+func runSupervisor(sv *supervisor) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		sv.run()
+		close(done)
+	}()
+	return done
+}
+
+// waitSupervisor waits for the supervisor to reach the state with the number of starts
+func waitSupervisor(t *testing.T, sv *supervisor, state string, starts uint64) *SupervisorDesc {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		sd := sv.Desc()
+		if sd.State == state && sd.Starts == starts {
+			return sd
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected supervisor %s after %d starts, got %s after %d", state, starts, sd.State, sd.Starts)
+		}
+	}
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor is expected to exit")
+	}
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	sv := newTestSupervisor(configreader.SourceTypeExec)
+	sv.source.config.ExecCommand = "exit 3"
+	done := runSupervisor(sv)
+
+	// the process exit is a failure delaying the next start
+	sd := waitSupervisor(t, sv, SupervisorStateBackingOff, 1)
+	if sd.Failures != 1 || !strings.Contains(sd.LastError, "exit status 3") || sd.NextAttempt == nil {
+		t.Errorf("unexpected supervisor state %+v", sd)
+	}
+
+	// a restart skips the backoff delay
+	sv.Restart()
+	waitSupervisor(t, sv, SupervisorStateBackingOff, 2)
+
+	sv.Stop()
+	waitDone(t, done)
+}
+
+func TestSupervisorStop(t *testing.T) {
+	sv := newTestSupervisor(configreader.SourceTypeExec)
+	sv.source.config.ExecCommand = "sleep 30"
+	done := runSupervisor(sv)
+
+	sd := waitSupervisor(t, sv, SupervisorStateRunning, 1)
+	sv.Stop()
+	waitDone(t, done)
+	if err := syscall.Kill(sd.Pid, 0); err != syscall.ESRCH {
+		t.Errorf("process %d is expected to be killed, got %v", sd.Pid, err)
+	}
+}
+
+func TestSupervisorFIFO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supervisor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	regular := filepath.Join(dir, "regular")
+	if err := ioutil.WriteFile(regular, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pipe := filepath.Join(dir, "pipe")
+	if err := syscall.Mkfifo(pipe, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNamedPipe(pipe); err != nil {
+		t.Errorf("unexpected error checking named pipe: %s", err)
+	}
+
+	sv := newTestSupervisor(configreader.SourceTypeFIFO)
+	for _, path := range []string{regular, filepath.Join(dir, "missing")} {
+		sv.source.config.FIFOPath = path
+		if err := sv.readFIFO(); err == nil {
+			t.Errorf("%s: reading a non-pipe is expected to fail", path)
+		}
+	}
+
+	// the pipe is reopened after the writer disconnects
+	sv.source.config.FIFOPath = pipe
+	done := runSupervisor(sv)
+	frame := make([]byte, 417)
+	copy(frame, testFrames[:4])
+	for i := 1; i <= 2; i++ {
+		w, err := os.OpenFile(pipe, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitSupervisor(t, sv, SupervisorStateRunning, uint64(i))
+		w.Write(frame)
+		w.Close()
+		// waiting for the reader to get EOF and close the pipe
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			sv.Lock()
+			closed := sv.kill == nil
+			sv.Unlock()
+			if closed {
+				break
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("pipe is expected to be closed after the writer disconnects")
+			}
+		}
+	}
+	sv.Stop()
+	// the reader blocked in opening the pipe gets a writer to exit
+	if w, err := os.OpenFile(pipe, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		w.Close()
+	}
+	waitDone(t, done)
+}
+
+func TestMetadataFIFOStop(t *testing.T) {
+	sv := newTestSupervisor(configreader.SourceTypeFIFO)
+	sv.source.config.MetadataFIFOPath = "/nonexistent/meta"
+	done := make(chan struct{})
+	go func() {
+		sv.readMetadataFIFO()
+		close(done)
+	}()
+	// the reopen delay is cut short
+	time.Sleep(50 * time.Millisecond)
+	sv.Stop()
+	waitDone(t, done)
+}
+
+func TestReadMetadataLines(t *testing.T) {
+	source := newTestPushSource()
+	source.currentMeta = icy.MetaData{"StreamTitle": "Previous"}
+	readMetadataLines(source, strings.NewReader("Song A\n\n  StreamUrl=http://example.com  \nStreamTitle=Song=B\n"))
+
+	if title := source.currentMeta["StreamTitle"]; title != "Song=B" {
+		t.Errorf("expected StreamTitle \"Song=B\", got %q", title)
+	}
+	if url := source.currentMeta["StreamUrl"]; url != "http://example.com" {
+		t.Errorf("expected StreamUrl \"http://example.com\", got %q", url)
+	}
+}
+
+func TestStop(t *testing.T) {
+	withTestMounts(func() {
+		sv := newTestSupervisor(configreader.SourceTypeExec)
+		sv.source.config.ExecCommand = "sleep 30"
+		sv.source.supervisor = sv
+		sourcesPathMap[sv.source.config.Path] = sv.source
+		done := runSupervisor(sv)
+
+		sd := waitSupervisor(t, sv, SupervisorStateRunning, 1)
+		Stop()
+		waitDone(t, done)
+		if err := syscall.Kill(sd.Pid, 0); err != syscall.ESRCH {
+			t.Errorf("process %d is expected to be killed, got %v", sd.Pid, err)
+		}
+	})
+}
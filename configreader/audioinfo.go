@@ -0,0 +1,91 @@
+package configreader
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ice-audio-info keys as sent by libshout compatible feeders
+const (
+	AudioInfoBitrate    = "ice-bitrate"
+	AudioInfoSampleRate = "ice-samplerate"
+	AudioInfoChannels   = "ice-channels"
+	AudioInfoQuality    = "ice-quality"
+)
+
+// ice-audio-info keys as sent to listeners
+const (
+	audioInfoRenderBitrate    = "br"
+	audioInfoRenderSampleRate = "samplerate"
+	audioInfoRenderChannels   = "channels"
+	audioInfoRenderQuality    = "quality"
+)
+
+// ParseAudioInfo updates stream audio parameters from an ice-audio-info
+// header value like "ice-samplerate=44100;ice-bitrate=128;ice-channels=2".
+// Keys without the "ice-" prefix and the "br" bitrate key are accepted as well
+func (sd *StreamDescription) ParseAudioInfo(info string) {
+	for _, token := range strings.Split(info, ";") {
+		kv := strings.SplitN(strings.TrimSpace(token), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToLower(kv[0])
+		if key == audioInfoRenderBitrate {
+			key = AudioInfoBitrate
+		} else if !strings.HasPrefix(key, "ice-") {
+			key = "ice-" + key
+		}
+		value, err := url.QueryUnescape(kv[1])
+		if err != nil {
+			value = kv[1]
+		}
+
+		switch key {
+		case AudioInfoBitrate:
+			if br, err := strconv.Atoi(value); err == nil && br > 0 {
+				sd.Bitrate = br
+			}
+		case AudioInfoSampleRate:
+			if sr, err := strconv.Atoi(value); err == nil && sr > 0 {
+				sd.SampleRate = sr
+			}
+		case AudioInfoChannels:
+			if ch, err := strconv.Atoi(value); err == nil && ch > 0 {
+				sd.Channels = ch
+			}
+		case AudioInfoQuality:
+			sd.Quality = value
+		}
+	}
+	sd.AudioInfo = sd.RenderAudioInfo()
+}
+
+// ResetAudioInfo sets the stream audio parameters back to the configured ones
+func (sd *StreamDescription) ResetAudioInfo(configured *StreamDescription) {
+	sd.Bitrate = configured.Bitrate
+	sd.SampleRate = configured.SampleRate
+	sd.Channels = configured.Channels
+	sd.Quality = configured.Quality
+	sd.AudioInfo = sd.RenderAudioInfo()
+}
+
+// RenderAudioInfo returns the ice-audio-info representation of the stream audio parameters
+func (sd *StreamDescription) RenderAudioInfo() string {
+	tokens := make([]string, 0, 4)
+	if sd.Bitrate > 0 {
+		tokens = append(tokens, fmt.Sprintf("%s=%d", audioInfoRenderBitrate, sd.Bitrate))
+	}
+	if sd.SampleRate > 0 {
+		tokens = append(tokens, fmt.Sprintf("%s=%d", audioInfoRenderSampleRate, sd.SampleRate))
+	}
+	if sd.Channels > 0 {
+		tokens = append(tokens, fmt.Sprintf("%s=%d", audioInfoRenderChannels, sd.Channels))
+	}
+	if sd.Quality != "" {
+		tokens = append(tokens, fmt.Sprintf("%s=%s", audioInfoRenderQuality, url.QueryEscape(sd.Quality)))
+	}
+	return strings.Join(tokens, ";")
+}
@@ -0,0 +1,78 @@
+package configreader
+
+import "testing"
+
+func TestParseAudioInfo(t *testing.T) {
+	cases := []struct {
+		info     string
+		expected StreamDescription
+	}{
+		{
+			"ice-samplerate=44100;ice-bitrate=128;ice-channels=2",
+			StreamDescription{Bitrate: 128, SampleRate: 44100, Channels: 2},
+		},
+		{
+			"samplerate=48000; bitrate=64 ;channels=1",
+			StreamDescription{Bitrate: 64, SampleRate: 48000, Channels: 1},
+		},
+		{
+			"br=96",
+			StreamDescription{Bitrate: 96, SampleRate: 22050, Channels: 2},
+		},
+		{
+			"ice-quality=0%2e5;ICE-BITRATE=112",
+			StreamDescription{Bitrate: 112, SampleRate: 22050, Channels: 2, Quality: "0.5"},
+		},
+		// invalid values keep the previous ones
+		{
+			"ice-bitrate=abc;ice-samplerate=-1;ice-channels=0;garbage;unknown=1",
+			StreamDescription{Bitrate: 32, SampleRate: 22050, Channels: 2},
+		},
+	}
+	for _, c := range cases {
+		sd := StreamDescription{Bitrate: 32, SampleRate: 22050, Channels: 2}
+		sd.ParseAudioInfo(c.info)
+		c.expected.AudioInfo = c.expected.RenderAudioInfo()
+		if sd != c.expected {
+			t.Errorf("%q: expected %+v, got %+v", c.info, c.expected, sd)
+		}
+	}
+}
+
+func TestRenderAudioInfo(t *testing.T) {
+	cases := []struct {
+		sd       StreamDescription
+		expected string
+	}{
+		{StreamDescription{}, ""},
+		{StreamDescription{Bitrate: 96}, "br=96"},
+		{StreamDescription{Bitrate: 128, SampleRate: 44100, Channels: 2}, "br=128;samplerate=44100;channels=2"},
+		{StreamDescription{SampleRate: 48000, Quality: "0.5 q"}, "samplerate=48000;quality=0.5+q"},
+	}
+	for _, c := range cases {
+		info := c.sd.RenderAudioInfo()
+		if info != c.expected {
+			t.Errorf("%+v: expected %q, got %q", c.sd, c.expected, info)
+		}
+		// the rendered value is parsed back to the same parameters
+		var sd StreamDescription
+		sd.ParseAudioInfo(info)
+		if sd.Bitrate != c.sd.Bitrate || sd.SampleRate != c.sd.SampleRate ||
+			sd.Channels != c.sd.Channels || sd.Quality != c.sd.Quality {
+			t.Errorf("%q: parsed back as %+v", info, sd)
+		}
+	}
+}
+
+func TestResetAudioInfo(t *testing.T) {
+	configured := StreamDescription{Bitrate: 96}
+	sd := configured
+	sd.ParseAudioInfo("ice-bitrate=320;ice-samplerate=48000;ice-channels=1;ice-quality=10")
+	sd.ResetAudioInfo(&configured)
+	if sd.Bitrate != 96 || sd.SampleRate != 0 || sd.Channels != 0 || sd.Quality != "" {
+		t.Errorf("expected configured audio parameters, got %+v", sd)
+	}
+	if sd.AudioInfo != "br=96" {
+		t.Errorf("expected audio info \"br=96\", got %q", sd.AudioInfo)
+	}
+}
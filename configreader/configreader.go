@@ -4,10 +4,16 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	logging "github.com/op/go-logging"
+	"github.com/viert/flamecast/cron"
 	"github.com/viert/properties"
 )
 
@@ -20,12 +26,28 @@ const (
 	DefaultBroadcastAuthType = "NONE"
 	DefaultLogfile           = "/var/log/flamecast.log"
 	DefaultLogLevel          = "ERROR"
+	DefaultSyncFrames        = 4
+	DefaultRetryInitial      = 1.0
+	DefaultRetryMax          = 60.0
+	DefaultRetryFactor       = 2.0
+	DefaultRetryJitter       = 0.2
+	DefaultRetryHealthy      = 30.0
+	DefaultProbeInterval     = 60.0
+	DefaultHLSSegmentTime    = 4.0
+	DefaultSourceTimeout     = 10.0
+	DefaultBackupSwitchBack  = 10.0
+	DefaultBackupStallTime   = 1.0
+	DefaultIdleTimeout       = 30.0
+	DefaultHLSSegments       = 6
 )
 
 // SourceType valid values
 const (
 	SourceTypePush = iota
 	SourceTypePull
+	SourceTypePlaylist
+	SourceTypeExec
+	SourceTypeFIFO
 )
 
 // BroadcastAuthType valid values
@@ -37,7 +59,7 @@ const (
 // Defaults and mappings
 var (
 	DefaultSourceBitrates = [...]byte{96, 112}
-	SourceTypes           = map[string]int{"PUSH": SourceTypePush, "PULL": SourceTypePull}
+	SourceTypes           = map[string]int{"PUSH": SourceTypePush, "PULL": SourceTypePull, "PLAYLIST": SourceTypePlaylist, "EXEC": SourceTypeExec, "FIFO": SourceTypeFIFO}
 	AuthTypes             = map[string]int{"NONE": BroadcastAuthTypeNone, "TOKEN": BroadcastAuthTypeToken}
 )
 
@@ -49,30 +71,94 @@ type (
 		Genre       string
 		Description string
 		Bitrate     int
+		SampleRate  int
+		Channels    int
+		Quality     string
 		AudioInfo   string
 	}
 
+	// RetryConfig describes exponential backoff of reconnect attempts
+	RetryConfig struct {
+		Initial time.Duration
+		Max     time.Duration
+		Factor  float64
+		Jitter  float64
+		Healthy time.Duration
+	}
+
 	SourceConfig struct {
 		Name                       string
 		Path                       string
 		FallbackPath               string
 		Type                       int
 		SourceAuthToken            string
+		OverrideAuthToken          string
+		BackupAuthToken            string
+		BackupSwitchBack           time.Duration
+		BackupStallTime            time.Duration
+		Takeover                   bool
+		ShoutcastPassword          string
 		SourcePullURL              *url.URL
+		SourcePullURLs             []*url.URL
+		PullProbeInterval          time.Duration
+		PullUserAgent              string
+		PullHeaders                map[string]string
+		OnDemand                   bool
+		IdleTimeout                time.Duration
+		PlaylistPath               string
+		PlaylistShuffle            bool
+		PlaylistRepeat             bool
+		ExecCommand                string
+		FIFOPath                   string
+		ContentType                string
+		MetadataFIFOPath           string
+		Pacing                     bool
+		Timeout                    time.Duration
+		GracePeriod                time.Duration
+		GraceSilence               bool
+		OfflineFile                string
+		OfflineSilence             bool
+		Intro                      *IntroDescription
+		Retry                      RetryConfig
 		Stream                     StreamDescription
+		ConfiguredStream           StreamDescription
 		BroadcastAuthType          int
 		BroadcastAuthTokenCheckURL *url.URL
 		BroadcastNotifyEnterURL    *url.URL
 		BroadcastNotifyLeaveURL    *url.URL
 	}
 
+	// ScheduleEntry maps the minutes matching the cron expression to a source
+	ScheduleEntry struct {
+		Name       string
+		When       string
+		Expression *cron.Expression
+		SourcePath string
+	}
+
+	// ScheduleConfig describes a public mount point playing the source of
+	// the first matching entry or the default source if none matches
+	ScheduleConfig struct {
+		Name              string
+		Path              string
+		DefaultSourcePath string
+		Entries           []*ScheduleEntry
+	}
+
 	Config struct {
-		Admin          string
-		Bind           string
-		LogFile        string
-		LogLevel       logging.Level
-		SourcesNameMap map[string]*SourceConfig
-		SourcesPathMap map[string]*SourceConfig
+		Admin               string
+		Bind                string
+		ShoutcastBind       string
+		LogFile             string
+		LogLevel            logging.Level
+		SyncFrames          int
+		SyncCheckCRC        bool
+		HLSSegmentTime      time.Duration
+		HLSSegments         int
+		SourcesNameMap      map[string]*SourceConfig
+		SourcesPathMap      map[string]*SourceConfig
+		SourcesShoutcastMap map[string]*SourceConfig
+		SchedulesPathMap    map[string]*ScheduleConfig
 	}
 )
 
@@ -110,6 +196,62 @@ func isValidAuthType(authType string) bool {
 	return exists
 }
 
+// shoutcastBindFromBind returns the SHOUTcast v1 source address which
+// is traditionally the main port + 1
+func shoutcastBindFromBind(bind string) (string, error) {
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "", err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(portNum+1)), nil
+}
+
+// readSeconds reads a (possibly fractional) number of seconds as time.Duration
+func readSeconds(props *properties.Properties, key string, defaultValue float64) (time.Duration, error) {
+	value, err := props.GetFloat(key)
+	if err != nil {
+		value = defaultValue
+	}
+	if value < 0 {
+		return 0, errors.New(key + " must not be negative")
+	}
+	return time.Duration(value * float64(time.Second)), nil
+}
+
+func readRetryConfig(props *properties.Properties, prefix string) (RetryConfig, error) {
+	var rc RetryConfig
+	var err error
+
+	if rc.Initial, err = readSeconds(props, prefix+"initial", DefaultRetryInitial); err != nil {
+		return rc, err
+	}
+	if rc.Max, err = readSeconds(props, prefix+"max", DefaultRetryMax); err != nil {
+		return rc, err
+	}
+	if rc.Healthy, err = readSeconds(props, prefix+"healthy", DefaultRetryHealthy); err != nil {
+		return rc, err
+	}
+	rc.Factor, err = props.GetFloat(prefix + "factor")
+	if err != nil {
+		rc.Factor = DefaultRetryFactor
+	}
+	if rc.Factor < 1 {
+		return rc, errors.New(prefix + "factor must be at least 1")
+	}
+	rc.Jitter, err = props.GetFloat(prefix + "jitter")
+	if err != nil {
+		rc.Jitter = DefaultRetryJitter
+	}
+	if rc.Jitter < 0 || rc.Jitter > 1 {
+		return rc, errors.New(prefix + "jitter must be between 0 and 1")
+	}
+	return rc, nil
+}
+
 // Load loads and parses config with a given filename
 func Load(filename string) (*Config, error) {
 	props, err := properties.Load(filename)
@@ -119,8 +261,10 @@ func Load(filename string) (*Config, error) {
 	}
 
 	cfg := &Config{
-		SourcesNameMap: make(map[string]*SourceConfig),
-		SourcesPathMap: make(map[string]*SourceConfig),
+		SourcesNameMap:      make(map[string]*SourceConfig),
+		SourcesPathMap:      make(map[string]*SourceConfig),
+		SourcesShoutcastMap: make(map[string]*SourceConfig),
+		SchedulesPathMap:    make(map[string]*ScheduleConfig),
 	}
 
 	// Server-wide options configuration
@@ -145,6 +289,24 @@ func Load(filename string) (*Config, error) {
 
 	cfg.Admin, _ = props.GetString("main.admin")
 
+	cfg.SyncFrames, err = props.GetInt("main.sync.frames")
+	if err != nil || cfg.SyncFrames < 1 {
+		cfg.SyncFrames = DefaultSyncFrames
+	}
+	cfg.SyncCheckCRC, _ = props.GetBool("main.sync.check_crc")
+
+	cfg.HLSSegmentTime, err = readSeconds(props, "main.hls.segment_time", DefaultHLSSegmentTime)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HLSSegmentTime < time.Second {
+		return nil, errors.New("main.hls.segment_time must be at least 1 second")
+	}
+	cfg.HLSSegments, err = props.GetInt("main.hls.segments")
+	if err != nil || cfg.HLSSegments < 3 {
+		cfg.HLSSegments = DefaultHLSSegments
+	}
+
 	if !props.KeyExists("sources") {
 		return nil, errors.New("No [sources.*] sections found")
 	}
@@ -196,6 +358,64 @@ func Load(filename string) (*Config, error) {
 		}
 		scfg.SourceAuthToken = base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
 
+		// a feeder with the override credentials replaces the current one
+		password, err = props.GetString(prefix + "source.override.password")
+		if err == nil {
+			if scfg.Type != SourceTypePush {
+				return nil, errors.New("source.override.password is only allowed for PUSH-type source " + sourceName)
+			}
+			user, err = props.GetString(prefix + "source.override.user")
+			if err != nil {
+				user = DefaultSourceUser
+			}
+			scfg.OverrideAuthToken = base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+			if scfg.OverrideAuthToken == scfg.SourceAuthToken {
+				return nil, errors.New("source.override credentials must differ from source.auth ones for source " + sourceName)
+			}
+		}
+		if scfg.Type == SourceTypePush {
+			scfg.Takeover, _ = props.GetBool(prefix + "source.takeover")
+		}
+
+		// a hot-standby feeder streams the same program from another encoder
+		password, err = props.GetString(prefix + "source.backup.password")
+		if err == nil {
+			if scfg.Type != SourceTypePush {
+				return nil, errors.New("source.backup.password is only allowed for PUSH-type source " + sourceName)
+			}
+			user, err = props.GetString(prefix + "source.backup.user")
+			if err != nil {
+				user = DefaultSourceUser
+			}
+			scfg.BackupAuthToken = base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+			if scfg.BackupAuthToken == scfg.SourceAuthToken || scfg.BackupAuthToken == scfg.OverrideAuthToken {
+				return nil, errors.New("source.backup credentials must differ from source.auth and source.override ones for source " + sourceName)
+			}
+			scfg.BackupSwitchBack, err = readSeconds(props, prefix+"source.backup.switch_back", DefaultBackupSwitchBack)
+			if err != nil {
+				return nil, errors.New("Invalid source.backup.switch_back for source " + sourceName + ": " + err.Error())
+			}
+			scfg.BackupStallTime, err = readSeconds(props, prefix+"source.backup.stall_time", DefaultBackupStallTime)
+			if err == nil && scfg.BackupStallTime == 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				return nil, errors.New("Invalid source.backup.stall_time for source " + sourceName + ": " + err.Error())
+			}
+		}
+
+		// SHOUTcast v1 feeders send a bare password so it must identify the mount
+		scfg.ShoutcastPassword, _ = props.GetString(prefix + "source.shoutcast.password")
+		if scfg.ShoutcastPassword != "" {
+			if scfg.Type != SourceTypePush {
+				return nil, errors.New("source.shoutcast.password is only allowed for PUSH-type source " + sourceName)
+			}
+			if other, exists := cfg.SourcesShoutcastMap[scfg.ShoutcastPassword]; exists {
+				return nil, errors.New("Duplicate source.shoutcast.password for sources " + other.Name + " and " + sourceName)
+			}
+			cfg.SourcesShoutcastMap[scfg.ShoutcastPassword] = scfg
+		}
+
 		if scfg.Type == SourceTypePull {
 			srcURL, err := props.GetString(prefix + "source.url")
 			if err != nil {
@@ -205,6 +425,122 @@ func Load(filename string) (*Config, error) {
 			if err != nil {
 				return nil, errors.New("Invalid source.url for source " + sourceName + ": " + err.Error())
 			}
+			// upstreams in priority order, the primary one goes first
+			scfg.SourcePullURLs = []*url.URL{scfg.SourcePullURL}
+			backupURLs, err := props.GetString(prefix + "source.backup_urls")
+			if err == nil {
+				for _, backupURL := range strings.Split(backupURLs, ",") {
+					backupURL = strings.TrimSpace(backupURL)
+					if backupURL == "" {
+						continue
+					}
+					u, err := url.Parse(backupURL)
+					if err != nil {
+						return nil, errors.New("Invalid URL in source.backup_urls for source " + sourceName + ": " + err.Error())
+					}
+					scfg.SourcePullURLs = append(scfg.SourcePullURLs, u)
+				}
+			}
+			scfg.PullProbeInterval, err = readSeconds(props, prefix+"source.probe_interval", DefaultProbeInterval)
+			if err != nil {
+				return nil, errors.New("Invalid source.probe_interval for source " + sourceName + ": " + err.Error())
+			}
+
+			scfg.PullUserAgent, _ = props.GetString(prefix + "source.user_agent")
+
+			// on-demand relays connect to upstream only while listened to
+			scfg.OnDemand, _ = props.GetBool(prefix + "source.on_demand")
+			scfg.IdleTimeout, err = readSeconds(props, prefix+"source.idle_timeout", DefaultIdleTimeout)
+			if err != nil {
+				return nil, errors.New("Invalid source.idle_timeout for source " + sourceName + ": " + err.Error())
+			}
+
+			// header names can't contain dashes in config keys, underscores are used instead
+			scfg.PullHeaders = make(map[string]string)
+			headerNames, err := props.Subkeys(prefix + "source.header")
+			if err == nil {
+				for _, name := range headerNames {
+					value, err := props.GetString(prefix + "source.header." + name)
+					if err != nil {
+						continue
+					}
+					scfg.PullHeaders[strings.Replace(name, "_", "-", -1)] = value
+				}
+			}
+		}
+
+		if scfg.Type == SourceTypeExec {
+			scfg.ExecCommand, err = props.GetString(prefix + "source.exec.command")
+			if err != nil {
+				return nil, errors.New("No source.exec.command for EXEC-type source " + sourceName)
+			}
+		}
+
+		if scfg.Type == SourceTypeFIFO {
+			scfg.FIFOPath, err = props.GetString(prefix + "source.fifo.path")
+			if err != nil {
+				return nil, errors.New("No source.fifo.path for FIFO-type source " + sourceName)
+			}
+		}
+
+		// the declared stream format, feeders and upstreams may override it
+		scfg.ContentType, _ = props.GetString(prefix + "source.content_type")
+		if scfg.Type == SourceTypeExec || scfg.Type == SourceTypeFIFO {
+			scfg.MetadataFIFOPath, _ = props.GetString(prefix + "source.metadata.fifo")
+		}
+
+		// pulled and playlist sources are paced by their nature
+		if scfg.Type == SourceTypePush || scfg.Type == SourceTypeExec || scfg.Type == SourceTypeFIFO {
+			scfg.Pacing, _ = props.GetBool(prefix + "source.pacing")
+		}
+
+		// feeders and upstreams sending no data are dropped after the timeout
+		if scfg.Type != SourceTypePlaylist {
+			scfg.Timeout, err = readSeconds(props, prefix+"source.timeout", DefaultSourceTimeout)
+			if err != nil {
+				return nil, errors.New("Invalid source.timeout for source " + sourceName + ": " + err.Error())
+			}
+		}
+
+		if scfg.Type == SourceTypePull || scfg.Type == SourceTypeExec || scfg.Type == SourceTypeFIFO {
+			scfg.Retry, err = readRetryConfig(props, prefix+"source.retry.")
+			if err != nil {
+				return nil, errors.New("Invalid source.retry for source " + sourceName + ": " + err.Error())
+			}
+		}
+
+		if scfg.Type == SourceTypePlaylist {
+			scfg.PlaylistPath, err = props.GetString(prefix + "source.playlist.path")
+			if err != nil {
+				return nil, errors.New("No source.playlist.path for PLAYLIST-type source " + sourceName)
+			}
+			if _, err = os.Stat(scfg.PlaylistPath); err != nil {
+				return nil, errors.New("Invalid source.playlist.path for source " + sourceName + ": " + err.Error())
+			}
+			scfg.PlaylistShuffle, _ = props.GetBool(prefix + "source.playlist.shuffle")
+			scfg.PlaylistRepeat, err = props.GetBool(prefix + "source.playlist.repeat")
+			if err != nil {
+				scfg.PlaylistRepeat = true
+			}
+		}
+
+		// listeners wait for the source to come back before failing over
+		scfg.GracePeriod, err = readSeconds(props, prefix+"source.grace_period", 0)
+		if err != nil {
+			return nil, errors.New("Invalid source.grace_period for source " + sourceName + ": " + err.Error())
+		}
+		scfg.GraceSilence, _ = props.GetBool(prefix + "source.grace_silence")
+
+		// listeners get a placeholder stream while nothing is live
+		scfg.OfflineFile, _ = props.GetString(prefix + "source.offline.file")
+		scfg.OfflineSilence, _ = props.GetBool(prefix + "source.offline.silence")
+		if scfg.OfflineFile != "" {
+			if scfg.OfflineSilence {
+				return nil, errors.New("source.offline.file and source.offline.silence are mutually exclusive for source " + sourceName)
+			}
+			if _, err = os.Stat(scfg.OfflineFile); err != nil {
+				return nil, errors.New("Invalid source.offline.file for source " + sourceName + ": " + err.Error())
+			}
 		}
 
 		broadcastAuthType, err := props.GetString(prefix + "broadcast.auth.type")
@@ -254,15 +590,40 @@ func Load(filename string) (*Config, error) {
 		if err != nil {
 			scfg.Stream.Bitrate = DefaultBitrate
 		}
-		scfg.Stream.AudioInfo = fmt.Sprintf("br=%d", scfg.Stream.Bitrate)
+		scfg.Stream.SampleRate, _ = props.GetInt(prefix + "source.samplerate")
+		scfg.Stream.Channels, _ = props.GetInt(prefix + "source.channels")
+		scfg.Stream.AudioInfo = scfg.Stream.RenderAudioInfo()
 		scfg.Stream.Public, _ = props.GetBool(prefix + "source.public")
 		scfg.Stream.Genre, _ = props.GetString(prefix + "source.genre")
 		scfg.Stream.URL, _ = props.GetString(prefix + "source.site")
 
+		// played to every new listener, the frames must be compatible with the stream
+		introPath, err := props.GetString(prefix + "source.intro")
+		if err == nil {
+			scfg.Intro, err = readIntro(introPath, &scfg.Stream)
+			if err != nil {
+				return nil, errors.New("Invalid source.intro for source " + sourceName + ": " + err.Error())
+			}
+		}
+
+		// feeders override the stream description for the time they're connected
+		scfg.ConfiguredStream = scfg.Stream
+
 		cfg.SourcesNameMap[sourceName] = scfg
 		cfg.SourcesPathMap[sourcePath] = scfg
 	}
 
+	// SHOUTcast v1 source listener is only started for sources having a password
+	if len(cfg.SourcesShoutcastMap) > 0 {
+		cfg.ShoutcastBind, err = props.GetString("main.shoutcast.bind")
+		if err != nil {
+			cfg.ShoutcastBind, err = shoutcastBindFromBind(cfg.Bind)
+			if err != nil {
+				return nil, errors.New("Can't derive main.shoutcast.bind from main.bind: " + err.Error())
+			}
+		}
+	}
+
 	// Second pass configuration - fallback sources
 	for sourceName, source := range cfg.SourcesNameMap {
 		fallbackName, err := props.GetString("sources." + sourceName + ".source.fallback")
@@ -276,5 +637,103 @@ func Load(filename string) (*Config, error) {
 		source.FallbackPath = fallbackSource.Path
 	}
 
+	// Fallback chains must end somewhere
+	for sourceName, source := range cfg.SourcesNameMap {
+		chain := []string{sourceName}
+		for source.FallbackPath != "" {
+			source = cfg.SourcesPathMap[source.FallbackPath]
+			chain = append(chain, source.Name)
+			if source.Name == sourceName {
+				return nil, errors.New("Fallback cycle found: " + strings.Join(chain, " -> "))
+			}
+			if len(chain) > len(cfg.SourcesNameMap) {
+				// a cycle further down the chain, it's reported for its own source
+				break
+			}
+		}
+	}
+
+	// Schedules configuration
+	if props.KeyExists("schedules") {
+		scheduleNames, err := props.Subkeys("schedules")
+		if err != nil {
+			return nil, err
+		}
+		for _, scheduleName := range scheduleNames {
+			schcfg, err := readSchedule(props, scheduleName, cfg)
+			if err != nil {
+				return nil, err
+			}
+			cfg.SchedulesPathMap[schcfg.Path] = schcfg
+		}
+	}
+
 	return cfg, nil
 }
+
+func scheduleSourcePath(props *properties.Properties, key string, cfg *Config) (string, error) {
+	sourceName, err := props.GetString(key)
+	if err != nil {
+		return "", err
+	}
+	source, ok := cfg.SourcesNameMap[sourceName]
+	if !ok {
+		return "", errors.New("no source named '" + sourceName + "'")
+	}
+	return source.Path, nil
+}
+
+func readSchedule(props *properties.Properties, scheduleName string, cfg *Config) (*ScheduleConfig, error) {
+	var err error
+	prefix := "schedules." + scheduleName + "."
+	schcfg := &ScheduleConfig{Name: scheduleName}
+
+	schcfg.Path, err = props.GetString(prefix + "schedule.path")
+	if err != nil {
+		schcfg.Path = "/" + scheduleName
+	}
+	if _, found := cfg.SourcesPathMap[schcfg.Path]; found {
+		return nil, errors.New("Path " + schcfg.Path + " of schedule " + scheduleName + " is already used by a source")
+	}
+	if other, found := cfg.SchedulesPathMap[schcfg.Path]; found {
+		return nil, errors.New("Duplicate path " + schcfg.Path + " for schedules " + other.Name + " and " + scheduleName)
+	}
+
+	if props.KeyExists(prefix + "schedule.default") {
+		schcfg.DefaultSourcePath, err = scheduleSourcePath(props, prefix+"schedule.default", cfg)
+		if err != nil {
+			return nil, errors.New("Invalid schedule.default for schedule " + scheduleName + ": " + err.Error())
+		}
+	}
+
+	if !props.KeyExists(prefix + "schedule.entry") {
+		return nil, errors.New("No schedule.entry.* for schedule " + scheduleName)
+	}
+	entryNames, err := props.Subkeys(prefix + "schedule.entry")
+	if err != nil {
+		return nil, err
+	}
+	// entries overlapping in time are prioritized by name
+	sort.Strings(entryNames)
+	for _, entryName := range entryNames {
+		entryPrefix := prefix + "schedule.entry." + entryName + "."
+		entry := &ScheduleEntry{Name: entryName}
+		entry.When, err = props.GetString(entryPrefix + "when")
+		if err != nil {
+			return nil, errors.New("No schedule.entry." + entryName + ".when for schedule " + scheduleName)
+		}
+		entry.Expression, err = cron.Parse(entry.When)
+		if err != nil {
+			return nil, errors.New("Invalid schedule.entry." + entryName + ".when for schedule " + scheduleName + ": " + err.Error())
+		}
+		if !props.KeyExists(entryPrefix + "source") {
+			return nil, errors.New("No schedule.entry." + entryName + ".source for schedule " + scheduleName)
+		}
+		entry.SourcePath, err = scheduleSourcePath(props, entryPrefix+"source", cfg)
+		if err != nil {
+			return nil, errors.New("Invalid schedule.entry." + entryName + ".source for schedule " + scheduleName + ": " + err.Error())
+		}
+		schcfg.Entries = append(schcfg.Entries, entry)
+	}
+	return schcfg, nil
+}
@@ -0,0 +1,93 @@
+package configreader
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadConfig(t *testing.T, data string) (*Config, error) {
+	f, err := ioutil.TempFile("", "flamecast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(data)
+	f.Close()
+	return Load(f.Name())
+}
+
+func TestShoutcastBind(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			"no shoutcast sources",
+			"[main]\nbind = :http\n[sources.live]\nsource.type = push\n",
+			"",
+		},
+		{
+			"derived",
+			"[main]\nbind = localhost:8000\n[sources.live]\nsource.type = push\nsource.shoutcast.password = hackme\n",
+			"localhost:8001",
+		},
+		{
+			"explicit",
+			"[main]\nbind = :http\nshoutcast.bind = :9000\n[sources.live]\nsource.type = push\nsource.shoutcast.password = hackme\n",
+			":9000",
+		},
+	}
+	for _, c := range cases {
+		cfg, err := loadConfig(t, c.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+			continue
+		}
+		if cfg.ShoutcastBind != c.expected {
+			t.Errorf("%s: expected shoutcast bind %q, got %q", c.name, c.expected, cfg.ShoutcastBind)
+		}
+	}
+
+	_, err := loadConfig(t, "[main]\nbind = :http\n[sources.live]\nsource.type = push\nsource.shoutcast.password = hackme\n")
+	if err == nil {
+		t.Error("expected an error deriving shoutcast bind from a named port")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	cfg, err := loadConfig(t, "[main]\n[sources.live]\nsource.type = push\nsource.content_type = audio/ogg\n")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if ct := cfg.SourcesNameMap["live"].ContentType; ct != "audio/ogg" {
+		t.Errorf("expected content type audio/ogg, got %q", ct)
+	}
+}
+
+func TestFallbackChains(t *testing.T) {
+	sources := "[main]\n[sources.live]\nsource.type = push\n[sources.relay]\nsource.type = push\n[sources.autodj]\nsource.type = push\n"
+
+	cfg, err := loadConfig(t, sources+"[sources.live]\nsource.fallback = relay\n[sources.relay]\nsource.fallback = autodj\n")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if path := cfg.SourcesNameMap["relay"].FallbackPath; path != "/autodj" {
+		t.Errorf("expected relay fallback /autodj, got %q", path)
+	}
+
+	cycles := []string{
+		"[sources.live]\nsource.fallback = live\n",
+		"[sources.live]\nsource.fallback = relay\n[sources.relay]\nsource.fallback = live\n",
+		// a cycle further down the chain
+		"[sources.live]\nsource.fallback = relay\n[sources.relay]\nsource.fallback = autodj\n[sources.autodj]\nsource.fallback = relay\n",
+	}
+	for _, c := range cycles {
+		_, err := loadConfig(t, sources+c)
+		if err == nil || !strings.Contains(err.Error(), "Fallback cycle found") {
+			t.Errorf("expected a fallback cycle error, got %v", err)
+		}
+	}
+}
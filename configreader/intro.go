@@ -0,0 +1,100 @@
+package configreader
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/viert/flamecast/id3"
+	"github.com/viert/flamecast/mpeg"
+)
+
+// maxIntroSize limits the intro file as it's kept in memory
+const maxIntroSize = 4 * 1024 * 1024
+
+// IntroDescription is a short MP3 file played to every new listener
+type IntroDescription struct {
+	Path       string
+	Data       []byte
+	SampleRate int
+	Channels   int
+}
+
+func frameChannels(hdr mpeg.FrameHeader) int {
+	if hdr.ChannelMode() == mpeg.ChannelModeSingleChannel {
+		return 1
+	}
+	return 2
+}
+
+// readIntro reads the MPEG frames of the intro file skipping the tags.
+// All the frames must have the same sample rate and channels matching
+// the stream ones if those are configured
+func readIntro(path string, stream *StreamDescription) (*IntroDescription, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxIntroSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxIntroSize)
+	}
+
+	intro := &IntroDescription{Path: path}
+	start := -1
+	pos := 0
+	for {
+		size := id3.TagSize(data[pos:])
+		if size == 0 || pos+size > len(data) {
+			break
+		}
+		pos += size
+	}
+	for pos+4 <= len(data) {
+		if !mpeg.FrameHeaderValid(data[pos:]) {
+			if start >= 0 {
+				// trailing tags
+				break
+			}
+			pos++
+			continue
+		}
+		hdr := mpeg.FrameHeader(data[pos : pos+4])
+		size := hdr.FrameSize()
+		if start < 0 && (size < 4 || hdr.Layer() != mpeg.Layer3 ||
+			pos+size+4 <= len(data) && !mpeg.FrameHeaderValid(data[pos+size:])) {
+			// false sync, the first frame must be followed by another one
+			pos++
+			continue
+		}
+		if size < 4 {
+			return nil, errors.New("free format bitrate is not supported")
+		}
+		if hdr.Layer() != mpeg.Layer3 {
+			return nil, errors.New("only MPEG Layer III is supported")
+		}
+		if pos+size > len(data) {
+			break
+		}
+		if start < 0 {
+			start = pos
+			intro.SampleRate = int(hdr.SampleRate())
+			intro.Channels = frameChannels(hdr)
+		} else if int(hdr.SampleRate()) != intro.SampleRate || frameChannels(hdr) != intro.Channels {
+			return nil, fmt.Errorf("frame at offset %d is of %d Hz, %d channels while the first one is of %d Hz, %d channels",
+				pos, hdr.SampleRate(), frameChannels(hdr), intro.SampleRate, intro.Channels)
+		}
+		pos += size
+	}
+	if start < 0 {
+		return nil, errors.New("no MPEG frames found")
+	}
+	intro.Data = data[start:pos]
+
+	if stream.SampleRate != 0 && stream.SampleRate != intro.SampleRate {
+		return nil, fmt.Errorf("intro sample rate %d Hz doesn't match source.samplerate %d Hz", intro.SampleRate, stream.SampleRate)
+	}
+	if stream.Channels != 0 && stream.Channels != intro.Channels {
+		return nil, fmt.Errorf("intro channels %d don't match source.channels %d", intro.Channels, stream.Channels)
+	}
+	return intro, nil
+}
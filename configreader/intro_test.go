@@ -0,0 +1,90 @@
+package configreader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/viert/flamecast/id3"
+	"github.com/viert/flamecast/mpeg"
+)
+
+func layer3Frames(t *testing.T, sampleRate int, channels int, count int) []byte {
+	hdr, err := mpeg.NewLayer3Header(sampleRate, 128, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Repeat(mpeg.SilentFrame(hdr), count)
+}
+
+func writeIntro(t *testing.T, dir string, name string, parts ...[]byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, bytes.Join(parts, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadIntro(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	frames := layer3Frames(t, 44100, 2, 10)
+	tag := id3.Render(id3.TextFrame("TIT2", "Welcome"))
+	tagV1 := append([]byte("TAG"), make([]byte, 125)...)
+
+	cases := []struct {
+		name       string
+		data       [][]byte
+		stream     StreamDescription
+		valid      bool
+		sampleRate int
+		channels   int
+	}{
+		{"frames only", [][]byte{frames}, StreamDescription{}, true, 44100, 2},
+		{"id3 tags", [][]byte{tag, tag, frames, tagV1}, StreamDescription{}, true, 44100, 2},
+		{"leading garbage", [][]byte{[]byte("garbage\xff\xfb"), frames}, StreamDescription{}, true, 44100, 2},
+		{"mono", [][]byte{layer3Frames(t, 48000, 1, 5)}, StreamDescription{}, true, 48000, 1},
+		{"matching stream", [][]byte{frames}, StreamDescription{SampleRate: 44100, Channels: 2}, true, 44100, 2},
+		{"sample rate mismatch", [][]byte{frames}, StreamDescription{SampleRate: 48000}, false, 0, 0},
+		{"channels mismatch", [][]byte{frames}, StreamDescription{Channels: 1}, false, 0, 0},
+		{"mixed sample rates", [][]byte{frames, layer3Frames(t, 48000, 2, 5)}, StreamDescription{}, false, 0, 0},
+		{"mixed channels", [][]byte{frames, layer3Frames(t, 44100, 1, 5)}, StreamDescription{}, false, 0, 0},
+		{"no frames", [][]byte{tag, []byte("not an mp3 file at all")}, StreamDescription{}, false, 0, 0},
+		{"empty", [][]byte{}, StreamDescription{}, false, 0, 0},
+		{"too large", [][]byte{make([]byte, maxIntroSize+1)}, StreamDescription{}, false, 0, 0},
+	}
+	for _, c := range cases {
+		path := writeIntro(t, dir, "intro.mp3", c.data...)
+		intro, err := readIntro(path, &c.stream)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%s: expected error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+			continue
+		}
+		if intro.SampleRate != c.sampleRate || intro.Channels != c.channels {
+			t.Errorf("%s: expected %d Hz, %d channels, got %d Hz, %d channels",
+				c.name, c.sampleRate, c.channels, intro.SampleRate, intro.Channels)
+		}
+		if len(intro.Data) == 0 || !mpeg.FrameHeaderValid(intro.Data) {
+			t.Errorf("%s: intro data is expected to start with a frame", c.name)
+		}
+		if len(intro.Data)%len(mpeg.SilentFrame(mpeg.FrameHeader(intro.Data[:4]))) != 0 {
+			t.Errorf("%s: intro data is expected to consist of complete frames, got %d bytes", c.name, len(intro.Data))
+		}
+	}
+
+	if _, err := readIntro(filepath.Join(dir, "missing.mp3"), &StreamDescription{}); err == nil {
+		t.Error("expected error reading a missing file")
+	}
+}
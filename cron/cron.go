@@ -0,0 +1,128 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// field is a set of allowed values of a cron expression field
+	field struct {
+		allowed [64]bool
+		any     bool
+	}
+
+	// Expression is a parsed cron-like expression of five fields:
+	// minute, hour, day of month, month and day of week. Each field is
+	// "*", a value, a range "a-b" or a list of those separated by commas,
+	// optionally with a step like "*/15" or "0-30/10". Day of week is
+	// 0-6 starting with Sunday, 7 is Sunday as well. As in cron if both
+	// day of month and day of week are restricted, either may match
+	Expression struct {
+		minute     field
+		hour       field
+		dayOfMonth field
+		month      field
+		dayOfWeek  field
+	}
+)
+
+var fieldNames = [...]string{"minute", "hour", "day of month", "month", "day of week"}
+
+func parseValue(s string, min int, max int) (int, error) {
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("value %d is out of range %d-%d", value, min, max)
+	}
+	return value, nil
+}
+
+func parseField(s string, min int, max int) (field, error) {
+	var f field
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step < 1 {
+				return f, fmt.Errorf("invalid step %q", part[idx+1:])
+			}
+			part = part[:idx]
+		}
+
+		var from, to int
+		var err error
+		switch {
+		case part == "*":
+			from, to = min, max
+			if step == 1 {
+				f.any = true
+			}
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			if from, err = parseValue(bounds[0], min, max); err != nil {
+				return f, err
+			}
+			if to, err = parseValue(bounds[1], min, max); err != nil {
+				return f, err
+			}
+			if from > to {
+				return f, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			if from, err = parseValue(part, min, max); err != nil {
+				return f, err
+			}
+			to = from
+			if step > 1 {
+				// "a/n" means "a-max/n"
+				to = max
+			}
+		}
+		for value := from; value <= to; value += step {
+			f.allowed[value] = true
+		}
+	}
+	return f, nil
+}
+
+// Parse parses a cron-like expression
+func Parse(expr string) (*Expression, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("expected 5 fields: minute, hour, day of month, month, day of week")
+	}
+	limits := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var parsed [5]field
+	for i, s := range fields {
+		f, err := parseField(s, limits[i][0], limits[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fieldNames[i], err.Error())
+		}
+		parsed[i] = f
+	}
+	// Sunday is both 0 and 7
+	if parsed[4].allowed[7] {
+		parsed[4].allowed[0] = true
+	}
+	return &Expression{parsed[0], parsed[1], parsed[2], parsed[3], parsed[4]}, nil
+}
+
+// Match returns true if the minute the time belongs to matches the expression
+func (e *Expression) Match(t time.Time) bool {
+	if !e.minute.allowed[t.Minute()] || !e.hour.allowed[t.Hour()] || !e.month.allowed[int(t.Month())] {
+		return false
+	}
+	dom := e.dayOfMonth.allowed[t.Day()]
+	dow := e.dayOfWeek.allowed[int(t.Weekday())]
+	if !e.dayOfMonth.any && !e.dayOfWeek.any {
+		return dom || dow
+	}
+	return dom && dow
+}
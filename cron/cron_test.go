@@ -0,0 +1,84 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseErrors(t *testing.T) {
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* 5-1 * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-2-3 * * * *",
+	}
+	for _, expr := range invalid {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("expected error parsing %q", expr)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		expr    string
+		time    string
+		matches bool
+	}{
+		{"* * * * *", "2026-10-16 21:05", true},
+		// weekdays from 20:00 to 21:59, 2026-10-16 is Friday
+		{"* 20-21 * * 1-5", "2026-10-16 20:00", true},
+		{"* 20-21 * * 1-5", "2026-10-16 21:59", true},
+		{"* 20-21 * * 1-5", "2026-10-16 22:00", false},
+		{"* 20-21 * * 1-5", "2026-10-17 20:30", false},
+		// nights
+		{"* 0-5 * * *", "2026-10-17 03:00", true},
+		{"* 0-5 * * *", "2026-10-17 06:00", false},
+		// lists and steps
+		{"0,30 * * * *", "2026-10-16 12:30", true},
+		{"0,30 * * * *", "2026-10-16 12:31", false},
+		{"*/15 * * * *", "2026-10-16 12:45", true},
+		{"*/15 * * * *", "2026-10-16 12:46", false},
+		{"10-30/10 * * * *", "2026-10-16 12:20", true},
+		{"10-30/10 * * * *", "2026-10-16 12:25", false},
+		{"5/20 * * * *", "2026-10-16 12:45", true},
+		// Sunday as 7, 2026-10-18 is Sunday
+		{"* * * * 7", "2026-10-18 12:00", true},
+		{"* * * * 0", "2026-10-18 12:00", true},
+		// months
+		{"* * * 12 *", "2026-10-16 12:00", false},
+		{"* * * 10 *", "2026-10-16 12:00", true},
+		// restricted day of month and day of week match either
+		{"* * 1 * 1", "2026-10-01 12:00", true},
+		{"* * 1 * 1", "2026-10-19 12:00", true},
+		{"* * 1 * 1", "2026-10-20 12:00", false},
+		// only one of them restricted must match
+		{"* * 1 * *", "2026-10-19 12:00", false},
+		{"* * */2 * *", "2026-10-19 12:00", true},
+	}
+	for _, c := range cases {
+		expr, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %s", c.expr, err)
+		}
+		if expr.Match(at(c.time)) != c.matches {
+			t.Errorf("%q at %s: expected match to be %v", c.expr, c.time, c.matches)
+		}
+	}
+}
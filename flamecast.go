@@ -45,6 +45,7 @@ func main() {
 		break
 	}
 
+	cast.Stop()
 	err = flameServer.Shutdown(nil)
 	if err != nil {
 		fmt.Printf("Error during graceful shutdown: %s\n", err)
@@ -1,5 +1,7 @@
 module github.com/viert/flamecast
 
+go 1.12
+
 require (
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/viert/endless v0.0.0-20190110111235-bd7a1691922b
@@ -0,0 +1,225 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Segment is a media segment of a media playlist
+	Segment struct {
+		URI           string
+		Duration      float64
+		Title         string
+		Sequence      uint64
+		Discontinuity bool
+	}
+
+	// MediaPlaylist is a playlist of media segments
+	MediaPlaylist struct {
+		TargetDuration        float64
+		MediaSequence         uint64
+		DiscontinuitySequence uint64
+		EndList               bool
+		Segments              []Segment
+	}
+
+	// Variant is a variant stream of a master playlist
+	Variant struct {
+		URI       string
+		Bandwidth int
+		Codecs    string
+	}
+
+	// Rendition is an alternative rendition of a master playlist
+	Rendition struct {
+		Type    string
+		URI     string
+		Default bool
+	}
+
+	// MasterPlaylist is a playlist of variant streams
+	MasterPlaylist struct {
+		Variants   []Variant
+		Renditions []Rendition
+	}
+)
+
+var (
+	// ErrNotPlaylist is returned if data is not an extended M3U playlist
+	ErrNotPlaylist = errors.New("not an HLS playlist")
+)
+
+// Parse parses an HLS playlist. Either a master or a media playlist is returned
+func Parse(data []byte) (*MasterPlaylist, *MediaPlaylist, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, nil, ErrNotPlaylist
+	}
+
+	master := &MasterPlaylist{}
+	media := &MediaPlaylist{}
+	isMaster := false
+	var segment Segment
+	var variant *Variant
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			if variant != nil {
+				variant.URI = line
+				master.Variants = append(master.Variants, *variant)
+				variant = nil
+				continue
+			}
+			segment.URI = line
+			segment.Sequence = media.MediaSequence + uint64(len(media.Segments))
+			media.Segments = append(media.Segments, segment)
+			segment = Segment{}
+			continue
+		}
+
+		tag, value := line, ""
+		if idx := strings.Index(line, ":"); idx >= 0 {
+			tag, value = line[:idx], line[idx+1:]
+		}
+		switch tag {
+		case "#EXT-X-TARGETDURATION":
+			media.TargetDuration, _ = strconv.ParseFloat(value, 64)
+		case "#EXT-X-MEDIA-SEQUENCE":
+			media.MediaSequence, _ = strconv.ParseUint(value, 10, 64)
+		case "#EXT-X-DISCONTINUITY-SEQUENCE":
+			media.DiscontinuitySequence, _ = strconv.ParseUint(value, 10, 64)
+		case "#EXT-X-ENDLIST":
+			media.EndList = true
+		case "#EXT-X-DISCONTINUITY":
+			segment.Discontinuity = true
+		case "#EXTINF":
+			tokens := strings.SplitN(value, ",", 2)
+			segment.Duration, _ = strconv.ParseFloat(strings.TrimSpace(tokens[0]), 64)
+			if len(tokens) > 1 {
+				segment.Title = tokens[1]
+			}
+		case "#EXT-X-STREAM-INF":
+			isMaster = true
+			attrs := parseAttributes(value)
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			variant = &Variant{Bandwidth: bandwidth, Codecs: attrs["CODECS"]}
+		case "#EXT-X-MEDIA":
+			isMaster = true
+			attrs := parseAttributes(value)
+			master.Renditions = append(master.Renditions, Rendition{
+				Type:    attrs["TYPE"],
+				URI:     attrs["URI"],
+				Default: attrs["DEFAULT"] == "YES",
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if isMaster {
+		return master, nil, nil
+	}
+	return nil, media, nil
+}
+
+// parseAttributes parses an attribute list like BANDWIDTH=128000,CODECS="mp4a.40.2"
+func parseAttributes(value string) map[string]string {
+	attrs := make(map[string]string)
+	for len(value) > 0 {
+		eq := strings.Index(value, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(value[:eq])
+		value = value[eq+1:]
+		var attr string
+		if strings.HasPrefix(value, "\"") {
+			end := strings.Index(value[1:], "\"")
+			if end < 0 {
+				attr, value = value[1:], ""
+			} else {
+				attr, value = value[1:end+1], value[end+2:]
+			}
+		} else {
+			end := strings.Index(value, ",")
+			if end < 0 {
+				attr, value = value, ""
+			} else {
+				attr, value = value[:end], value[end:]
+			}
+		}
+		attrs[key] = attr
+		value = strings.TrimPrefix(value, ",")
+	}
+	return attrs
+}
+
+// AudioURI returns the URI of the media playlist best suited for audio
+// relaying: the default audio rendition if any, otherwise the variant with
+// the highest bandwidth preferring audio-only variants
+func (mp *MasterPlaylist) AudioURI() string {
+	for _, r := range mp.Renditions {
+		if r.Type == "AUDIO" && r.Default && r.URI != "" {
+			return r.URI
+		}
+	}
+	best := -1
+	bestAudioOnly := false
+	for i, v := range mp.Variants {
+		audioOnly := v.Codecs != "" && isAudioOnly(v.Codecs)
+		if best < 0 ||
+			(audioOnly && !bestAudioOnly) ||
+			(audioOnly == bestAudioOnly && v.Bandwidth > mp.Variants[best].Bandwidth) {
+			best = i
+			bestAudioOnly = audioOnly
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return mp.Variants[best].URI
+}
+
+func isAudioOnly(codecs string) bool {
+	for _, codec := range strings.Split(codecs, ",") {
+		codec = strings.TrimSpace(codec)
+		if !strings.HasPrefix(codec, "mp4a") && codec != "mp3" && !strings.HasPrefix(codec, "ac-3") &&
+			!strings.HasPrefix(codec, "ec-3") && codec != "opus" && codec != "flac" {
+			return false
+		}
+	}
+	return true
+}
+
+// Render renders the media playlist
+func (mp *MediaPlaylist) Render() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(buf, "#EXT-X-TARGETDURATION:%d\n", int(math.Round(mp.TargetDuration)))
+	fmt.Fprintf(buf, "#EXT-X-MEDIA-SEQUENCE:%d\n", mp.MediaSequence)
+	if mp.DiscontinuitySequence > 0 {
+		fmt.Fprintf(buf, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", mp.DiscontinuitySequence)
+	}
+	for _, segment := range mp.Segments {
+		if segment.Discontinuity {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(buf, "#EXTINF:%.3f,%s\n%s\n", segment.Duration, segment.Title, segment.URI)
+	}
+	if mp.EndList {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return buf.Bytes()
+}
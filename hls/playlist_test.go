@@ -0,0 +1,85 @@
+package hls
+
+import (
+	"testing"
+)
+
+func TestParseMedia(t *testing.T) {
+	data := []byte(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:120
+#EXTINF:9.98,Artist - Title
+seg120.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:10.0,
+seg121.ts
+`)
+	master, media, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if master != nil || media == nil {
+		t.Fatal("media playlist expected")
+	}
+	if media.TargetDuration != 10 || media.MediaSequence != 120 || media.EndList {
+		t.Errorf("unexpected playlist properties %+v", media)
+	}
+	if len(media.Segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(media.Segments))
+	}
+	s := media.Segments[1]
+	if s.URI != "seg121.ts" || s.Sequence != 121 || !s.Discontinuity || s.Duration != 10 {
+		t.Errorf("unexpected segment %+v", s)
+	}
+	if media.Segments[0].Title != "Artist - Title" {
+		t.Errorf("unexpected segment title %q", media.Segments[0].Title)
+	}
+}
+
+func TestParseMaster(t *testing.T) {
+	data := []byte(`#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=2000000,CODECS="avc1.4d401f,mp4a.40.2"
+video.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=64000,CODECS="mp4a.40.5"
+aac64.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS="mp4a.40.2"
+aac128.m3u8
+`)
+	master, media, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if media != nil || master == nil {
+		t.Fatal("master playlist expected")
+	}
+	if len(master.Variants) != 3 {
+		t.Fatalf("expected 3 variants, got %d", len(master.Variants))
+	}
+	if uri := master.AudioURI(); uri != "aac128.m3u8" {
+		t.Errorf("expected aac128.m3u8 to be chosen, got %s", uri)
+	}
+}
+
+func TestRender(t *testing.T) {
+	src := &MediaPlaylist{
+		TargetDuration:        4,
+		MediaSequence:         7,
+		DiscontinuitySequence: 1,
+		Segments: []Segment{
+			{URI: "7.aac", Duration: 4.017},
+			{URI: "8.aac", Duration: 3.5, Discontinuity: true},
+		},
+	}
+	_, media, err := Parse(src.Render())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if media.MediaSequence != 7 || media.DiscontinuitySequence != 1 || media.TargetDuration != 4 {
+		t.Errorf("unexpected playlist properties %+v", media)
+	}
+	if len(media.Segments) != 2 || media.Segments[1].URI != "8.aac" || !media.Segments[1].Discontinuity ||
+		media.Segments[1].Sequence != 8 {
+		t.Errorf("unexpected segments %+v", media.Segments)
+	}
+}
@@ -0,0 +1,263 @@
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+type (
+	// Frame is a single ID3v2 frame
+	Frame struct {
+		ID   string
+		Data []byte
+	}
+
+	// Tag is a parsed ID3v2 tag
+	Tag struct {
+		Version byte
+		Size    int
+		Frames  []Frame
+	}
+)
+
+// HeaderSize is the size of ID3v2 tag header
+const HeaderSize = 10
+
+// Text encodings as documented at http://id3.org/id3v2.4.0-structure
+const (
+	EncodingISO88591 = iota
+	EncodingUTF16
+	EncodingUTF16BE
+	EncodingUTF8
+)
+
+var (
+	// ErrNoTag is returned when data doesn't start with an ID3v2 tag
+	ErrNoTag = errors.New("no ID3v2 tag found")
+
+	v22FrameIDs = map[string]string{
+		"TT2": "TIT2",
+		"TP1": "TPE1",
+		"TAL": "TALB",
+		"TXX": "TXXX",
+	}
+)
+
+func syncsafe(data []byte) int {
+	return int(data[0]&0x7F)<<21 | int(data[1]&0x7F)<<14 | int(data[2]&0x7F)<<7 | int(data[3]&0x7F)
+}
+
+// TagSize returns the full size of the ID3v2 tag at the beginning
+// of data including header and footer or 0 if there's no tag
+func TagSize(data []byte) int {
+	if len(data) < HeaderSize || !bytes.HasPrefix(data, []byte("ID3")) {
+		return 0
+	}
+	if data[3] == 0xFF || data[4] == 0xFF ||
+		data[6]&0x80 != 0 || data[7]&0x80 != 0 || data[8]&0x80 != 0 || data[9]&0x80 != 0 {
+		return 0
+	}
+	size := HeaderSize + syncsafe(data[6:10])
+	// footer present
+	if data[3] == 4 && data[5]&0x10 != 0 {
+		size += HeaderSize
+	}
+	return size
+}
+
+// Parse parses an ID3v2 tag at the beginning of data
+func Parse(data []byte) (*Tag, error) {
+	size := TagSize(data)
+	if size == 0 {
+		return nil, ErrNoTag
+	}
+	if len(data) < size {
+		return nil, errors.New("ID3v2 tag is truncated")
+	}
+	tag := &Tag{Version: data[3], Size: size}
+	flags := data[5]
+	body := data[HeaderSize : HeaderSize+syncsafe(data[6:10])]
+
+	if flags&0x80 != 0 && tag.Version < 4 {
+		// tag-level unsynchronisation
+		body = bytes.Replace(body, []byte{0xFF, 0x00}, []byte{0xFF}, -1)
+	}
+	if flags&0x40 != 0 && tag.Version >= 3 {
+		// skipping extended header
+		if len(body) < 4 {
+			return nil, errors.New("invalid extended header")
+		}
+		extSize := int(binary.BigEndian.Uint32(body[:4]))
+		if tag.Version == 3 {
+			extSize += 4
+		} else {
+			extSize = syncsafe(body[:4])
+		}
+		if extSize > len(body) {
+			return nil, errors.New("invalid extended header")
+		}
+		body = body[extSize:]
+	}
+
+	for len(body) > 0 {
+		var id string
+		var frameSize, headerSize int
+		if tag.Version == 2 {
+			headerSize = 6
+			if len(body) < headerSize {
+				break
+			}
+			id = string(body[:3])
+			frameSize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+			if mapped, found := v22FrameIDs[id]; found {
+				id = mapped
+			}
+		} else {
+			headerSize = 10
+			if len(body) < headerSize {
+				break
+			}
+			id = string(body[:4])
+			if tag.Version == 4 {
+				frameSize = syncsafe(body[4:8])
+			} else {
+				frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+			}
+		}
+		if id[0] == 0 {
+			// padding
+			break
+		}
+		if frameSize < 0 || headerSize+frameSize > len(body) {
+			return tag, errors.New("ID3v2 frame is truncated")
+		}
+		tag.Frames = append(tag.Frames, Frame{id, body[headerSize : headerSize+frameSize]})
+		body = body[headerSize+frameSize:]
+	}
+	return tag, nil
+}
+
+// DecodeText decodes an encoded text string
+func DecodeText(encoding byte, data []byte) string {
+	switch encoding {
+	case EncodingUTF16, EncodingUTF16BE:
+		bigEndian := encoding == EncodingUTF16BE
+		if len(data) >= 2 {
+			if data[0] == 0xFF && data[1] == 0xFE {
+				bigEndian = false
+				data = data[2:]
+			} else if data[0] == 0xFE && data[1] == 0xFF {
+				bigEndian = true
+				data = data[2:]
+			}
+		}
+		u16 := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			if bigEndian {
+				u16 = append(u16, uint16(data[i])<<8|uint16(data[i+1]))
+			} else {
+				u16 = append(u16, uint16(data[i+1])<<8|uint16(data[i]))
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(u16)), "\x00")
+	case EncodingUTF8:
+		return strings.TrimRight(string(data), "\x00")
+	default:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return strings.TrimRight(string(runes), "\x00")
+	}
+}
+
+// Text returns the value of a text frame with a given id
+func (t *Tag) Text(id string) string {
+	for _, frame := range t.Frames {
+		if frame.ID == id && len(frame.Data) > 0 {
+			return DecodeText(frame.Data[0], frame.Data[1:])
+		}
+	}
+	return ""
+}
+
+// UserText returns the value of a TXXX frame with a given description
+func (t *Tag) UserText(description string) string {
+	for _, frame := range t.Frames {
+		if frame.ID != "TXXX" || len(frame.Data) < 2 {
+			continue
+		}
+		encoding := frame.Data[0]
+		desc, value := splitEncoded(encoding, frame.Data[1:])
+		if DecodeText(encoding, desc) == description {
+			return DecodeText(encoding, value)
+		}
+	}
+	return ""
+}
+
+// Title returns the title of the track
+func (t *Tag) Title() string {
+	return t.Text("TIT2")
+}
+
+// Artist returns the artist of the track
+func (t *Tag) Artist() string {
+	return t.Text("TPE1")
+}
+
+// StreamTitle returns the "Artist - Title" string suitable for icy metadata
+func (t *Tag) StreamTitle() string {
+	if title := t.UserText("StreamTitle"); title != "" {
+		return title
+	}
+	artist := t.Artist()
+	title := t.Title()
+	if artist != "" && title != "" {
+		return artist + " - " + title
+	}
+	return artist + title
+}
+
+// splitEncoded splits a null-terminated string from the rest of data
+func splitEncoded(encoding byte, data []byte) ([]byte, []byte) {
+	if encoding == EncodingUTF16 || encoding == EncodingUTF16BE {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return data[:i], data[i+2:]
+			}
+		}
+		return data, nil
+	}
+	idx := bytes.IndexByte(data, 0)
+	if idx < 0 {
+		return data, nil
+	}
+	return data[:idx], data[idx+1:]
+}
+
+// V1Size is the size of an ID3v1 tag
+const V1Size = 128
+
+// ParseV1 parses an ID3v1 tag at the end of data. The tag is returned
+// with the title and the artist as text frames
+func ParseV1(data []byte) (*Tag, error) {
+	if len(data) < V1Size || !bytes.HasPrefix(data[len(data)-V1Size:], []byte("TAG")) {
+		return nil, ErrNoTag
+	}
+	raw := data[len(data)-V1Size:]
+	tag := &Tag{Version: 1, Size: V1Size}
+	trim := func(field []byte) []byte {
+		return bytes.TrimRight(bytes.TrimRight(field, "\x00"), " ")
+	}
+	if title := trim(raw[3:33]); len(title) > 0 {
+		tag.Frames = append(tag.Frames, Frame{"TIT2", append([]byte{EncodingISO88591}, title...)})
+	}
+	if artist := trim(raw[33:63]); len(artist) > 0 {
+		tag.Frames = append(tag.Frames, Frame{"TPE1", append([]byte{EncodingISO88591}, artist...)})
+	}
+	return tag, nil
+}
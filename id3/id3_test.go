@@ -0,0 +1,83 @@
+package id3
+
+import (
+	"testing"
+)
+
+func buildTag(frames ...[]byte) []byte {
+	body := make([]byte, 0)
+	for _, f := range frames {
+		body = append(body, f...)
+	}
+	size := len(body)
+	header := []byte{'I', 'D', '3', 4, 0, 0,
+		byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(header, body...)
+}
+
+func buildFrame(id string, data []byte) []byte {
+	size := len(data)
+	frame := []byte(id)
+	frame = append(frame, byte(size>>21&0x7F), byte(size>>14&0x7F), byte(size>>7&0x7F), byte(size&0x7F), 0, 0)
+	return append(frame, data...)
+}
+
+func TestParse(t *testing.T) {
+	data := buildTag(
+		buildFrame("TIT2", append([]byte{EncodingUTF8}, "Title"...)),
+		buildFrame("TPE1", append([]byte{EncodingISO88591}, "Artist"...)),
+	)
+	data = append(data, 0xFF, 0xFB)
+
+	if size := TagSize(data); size != len(data)-2 {
+		t.Fatalf("expected tag size %d, got %d", len(data)-2, size)
+	}
+	tag, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if title := tag.StreamTitle(); title != "Artist - Title" {
+		t.Errorf("unexpected stream title %q", title)
+	}
+}
+
+func TestNoTag(t *testing.T) {
+	if _, err := Parse([]byte{0xFF, 0xFB, 0x90, 0x64, 0, 0, 0, 0, 0, 0}); err != ErrNoTag {
+		t.Errorf("expected ErrNoTag, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := Render(TimestampFrame(1<<33+90000), TextFrame("TIT2", "Title"), UserTextFrame("StreamTitle", "Artist - Title"))
+	tag, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tag.Size != len(data) {
+		t.Errorf("expected tag size %d, got %d", len(data), tag.Size)
+	}
+	if pts, ok := tag.Timestamp(); !ok || pts != 90000 {
+		t.Errorf("unexpected timestamp %d", pts)
+	}
+	if title := tag.StreamTitle(); title != "Artist - Title" {
+		t.Errorf("unexpected stream title %q", title)
+	}
+}
+
+func TestParseV1(t *testing.T) {
+	raw := make([]byte, V1Size)
+	copy(raw, "TAG")
+	copy(raw[3:], "Title")
+	copy(raw[33:], "Artist  ")
+	data := append([]byte{0xFF, 0xFB, 0x90, 0x64}, raw...)
+	tag, err := ParseV1(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if title := tag.StreamTitle(); title != "Artist - Title" {
+		t.Errorf("unexpected stream title %q", title)
+	}
+	if _, err := ParseV1(data[:V1Size]); err != ErrNoTag {
+		t.Errorf("expected ErrNoTag, got %v", err)
+	}
+}
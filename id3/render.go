@@ -0,0 +1,61 @@
+package id3
+
+import (
+	"encoding/binary"
+)
+
+// TransportStreamTimestampOwner is the owner of the PRIV frame carrying
+// the timestamp of packed audio HLS segments
+const TransportStreamTimestampOwner = "com.apple.streaming.transportStreamTimestamp"
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// TextFrame creates a UTF-8 text frame
+func TextFrame(id string, text string) Frame {
+	data := append([]byte{EncodingUTF8}, text...)
+	return Frame{id, data}
+}
+
+// UserTextFrame creates a UTF-8 TXXX frame
+func UserTextFrame(description string, text string) Frame {
+	data := append([]byte{EncodingUTF8}, description...)
+	data = append(data, 0)
+	data = append(data, text...)
+	return Frame{"TXXX", data}
+}
+
+// TimestampFrame creates a PRIV frame with a 33-bit MPEG-2 transport stream
+// timestamp (90kHz) as required for packed audio HLS segments
+func TimestampFrame(pts uint64) Frame {
+	data := append([]byte(TransportStreamTimestampOwner), 0)
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, pts&(1<<33-1))
+	return Frame{"PRIV", append(data, ts...)}
+}
+
+// Render renders an ID3v2.4 tag containing the frames
+func Render(frames ...Frame) []byte {
+	body := make([]byte, 0)
+	for _, frame := range frames {
+		body = append(body, frame.ID...)
+		body = append(body, syncsafeBytes(len(frame.Data))...)
+		body = append(body, 0, 0)
+		body = append(body, frame.Data...)
+	}
+	tag := []byte{'I', 'D', '3', 4, 0, 0}
+	tag = append(tag, syncsafeBytes(len(body))...)
+	return append(tag, body...)
+}
+
+// Timestamp returns the transport stream timestamp of the tag if present
+func (t *Tag) Timestamp() (uint64, bool) {
+	owner := []byte(TransportStreamTimestampOwner + "\x00")
+	for _, frame := range t.Frames {
+		if frame.ID == "PRIV" && len(frame.Data) == len(owner)+8 && string(frame.Data[:len(owner)]) == string(owner) {
+			return binary.BigEndian.Uint64(frame.Data[len(owner):]) & (1<<33 - 1), true
+		}
+	}
+	return 0, false
+}
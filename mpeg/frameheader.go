@@ -303,3 +303,43 @@ func FrameHeaderValid(data []byte) bool {
 	}
 	return false
 }
+
+// SilentFrame returns a frame with the same parameters as the header
+// which decodes to silence: all the side info and audio data is zeroed
+func SilentFrame(fh FrameHeader) []byte {
+	hdr := FrameHeader{fh[0], fh[1] | 0x01, fh[2] &^ 0x02, fh[3]}
+	frame := make([]byte, hdr.FrameSize())
+	copy(frame, hdr)
+	return frame
+}
+
+// NewLayer3Header returns an unprotected and unpadded MPEG Layer III frame
+// header for the sample rate (Hz), the bit rate (kbps) and the number
+// of channels given
+func NewLayer3Header(sampleRate int, bitRate int, channels int) (FrameHeader, error) {
+	mode := ChannelModeStereo
+	if channels == 1 {
+		mode = ChannelModeSingleChannel
+	} else if channels != 2 {
+		return nil, errors.New("only mono and stereo frames are supported")
+	}
+	for _, version := range []FrameMPEGVersion{VersionMPEG1, VersionMPEG2, VersionMPEG2_5} {
+		for srIndex := 0; srIndex < 3; srIndex++ {
+			if int(sampleRates[version][srIndex]) != sampleRate {
+				continue
+			}
+			for brIndex := 1; brIndex < 15; brIndex++ {
+				if int(bitRates[version][Layer3][brIndex]) == bitRate {
+					return FrameHeader{
+						0xFF,
+						0xE0 | byte(version)<<3 | byte(Layer3)<<1 | 0x01,
+						byte(brIndex)<<4 | byte(srIndex)<<2,
+						byte(mode) << 6,
+					}, nil
+				}
+			}
+			return nil, errors.New("bit rate is not valid for the sample rate")
+		}
+	}
+	return nil, errors.New("sample rate is not valid")
+}
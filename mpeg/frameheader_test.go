@@ -0,0 +1,63 @@
+package mpeg
+
+import (
+	"testing"
+)
+
+func TestSilentFrame(t *testing.T) {
+	// protected and padded version of header128
+	hdr := FrameHeader{0xFF, 0xFA, 0x92, 0x64}
+	if !hdr.Protected() || !hdr.Pad() || hdr.FrameSize() != 418 {
+		t.Fatalf("unexpected test header properties")
+	}
+	frame := SilentFrame(hdr)
+	if len(frame) != 417 {
+		t.Errorf("expected silent frame size 417, got %d", len(frame))
+	}
+	silent := FrameHeader(frame[:4])
+	if silent.Protected() || silent.Pad() || silent.SampleRate() != hdr.SampleRate() || silent.BitRate() != hdr.BitRate() {
+		t.Errorf("unexpected silent frame header % x", frame[:4])
+	}
+	for _, b := range frame[4:] {
+		if b != 0 {
+			t.Fatal("silent frame data must be zeroed")
+		}
+	}
+}
+
+func TestNewLayer3Header(t *testing.T) {
+	hdr, err := NewLayer3Header(44100, 128, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !FrameHeaderValid(hdr) {
+		t.Fatalf("header % x is not valid", []byte(hdr))
+	}
+	if hdr.Layer() != Layer3 || hdr.Version() != VersionMPEG1 || hdr.Protected() || hdr.Pad() {
+		t.Errorf("unexpected header % x", []byte(hdr))
+	}
+	if hdr.SampleRate() != 44100 || hdr.BitRate() != 128000 || hdr.ChannelMode() != ChannelModeStereo {
+		t.Errorf("unexpected header parameters %d %d %d", hdr.SampleRate(), hdr.BitRate(), hdr.ChannelMode())
+	}
+	if hdr.FrameSize() != 417 {
+		t.Errorf("expected frame size 417, got %d", hdr.FrameSize())
+	}
+
+	hdr, err = NewLayer3Header(22050, 32, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Version() != VersionMPEG2 || hdr.SampleRate() != 22050 || hdr.BitRate() != 32000 || hdr.ChannelMode() != ChannelModeSingleChannel {
+		t.Errorf("unexpected header % x", []byte(hdr))
+	}
+
+	if _, err = NewLayer3Header(44100, 8, 2); err == nil {
+		t.Error("8 kbps is not valid for MPEG-1, error expected")
+	}
+	if _, err = NewLayer3Header(44000, 128, 2); err == nil {
+		t.Error("invalid sample rate error expected")
+	}
+	if _, err = NewLayer3Header(44100, 128, 6); err == nil {
+		t.Error("invalid channels error expected")
+	}
+}
@@ -0,0 +1,120 @@
+package mpeg
+
+import (
+	"errors"
+)
+
+type (
+	// Scanner looks for a reliable sync point in MPEG audio data. A header
+	// is considered a sync point only if it's followed by a number of
+	// consecutive frames with the same stream parameters
+	Scanner struct {
+		frames   int
+		checkCRC bool
+	}
+)
+
+var (
+	// ErrNeedMoreData is returned when data ends before a sync point candidate
+	// could be confirmed. The offset of the candidate is returned along with the error
+	ErrNeedMoreData = errors.New("not enough data to confirm frame sync")
+	// ErrNoSync is returned when no sync point is found in data
+	ErrNoSync = errors.New("no valid frame found")
+)
+
+// NewScanner creates a new Scanner confirming sync by _frames_ consecutive frames
+// and optionally verifying CRC of protected Layer III frames
+func NewScanner(frames int, checkCRC bool) *Scanner {
+	if frames < 1 {
+		frames = 1
+	}
+	return &Scanner{frames, checkCRC}
+}
+
+// Sync returns the offset of the first confirmed frame in data
+func (sc *Scanner) Sync(data []byte) (int, error) {
+	for i := 0; i+4 <= len(data); i++ {
+		if !FrameHeaderValid(data[i:]) {
+			continue
+		}
+		confirmed, complete := sc.walk(data[i:])
+		if confirmed {
+			return i, nil
+		}
+		if !complete {
+			return i, ErrNeedMoreData
+		}
+	}
+	return 0, ErrNoSync
+}
+
+// walk follows the chain of frames starting at the beginning of data.
+// complete is false when data ends before the chain could be checked
+func (sc *Scanner) walk(data []byte) (confirmed bool, complete bool) {
+	first := FrameHeader(data[:4])
+	pos := 0
+	for n := 0; n < sc.frames; n++ {
+		if pos+4 > len(data) {
+			return false, false
+		}
+		if !FrameHeaderValid(data[pos:]) {
+			return false, true
+		}
+		hdr := FrameHeader(data[pos : pos+4])
+		if !SameStream(first, hdr) {
+			return false, true
+		}
+		size := hdr.FrameSize()
+		if size < 4 {
+			// free format bitrate can't be followed
+			return false, true
+		}
+		if pos+size > len(data) {
+			return false, false
+		}
+		if sc.checkCRC && !CRCValid(data[pos:pos+size]) {
+			return false, true
+		}
+		pos += size
+	}
+	return true, true
+}
+
+// SameStream returns true if both headers may belong to the same stream,
+// i.e. all the parameters but the bitrate and padding are the same
+func SameStream(a, b FrameHeader) bool {
+	return a.Version() == b.Version() &&
+		a.Layer() == b.Layer() &&
+		a.SampleRate() == b.SampleRate() &&
+		(a.ChannelMode() == ChannelModeSingleChannel) == (b.ChannelMode() == ChannelModeSingleChannel)
+}
+
+// CRCValid verifies the CRC of a protected Layer III frame. Unprotected frames
+// and frames of other layers are always considered valid
+func CRCValid(frame []byte) bool {
+	hdr := FrameHeader(frame[:4])
+	if !hdr.Protected() || hdr.Layer() != Layer3 {
+		return true
+	}
+	sideInfoLength, err := hdr.SideInfoLength()
+	if err != nil || len(frame) < 6+sideInfoLength {
+		return false
+	}
+	crc := crc16(0xFFFF, frame[2:4])
+	crc = crc16(crc, frame[6:6+sideInfoLength])
+	return crc == uint16(frame[4])<<8|uint16(frame[5])
+}
+
+func crc16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = (crc << 1) ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,73 @@
+package mpeg
+
+import (
+	"testing"
+)
+
+// MPEG-1 Layer III, 128kbps, 44100Hz, stereo, 417 bytes per frame
+var header128 = []byte{0xFF, 0xFB, 0x90, 0x64}
+
+func makeFrames(n int) []byte {
+	data := make([]byte, 0, n*417)
+	for i := 0; i < n; i++ {
+		frame := make([]byte, 417)
+		copy(frame, header128)
+		data = append(data, frame...)
+	}
+	return data
+}
+
+func TestScannerSync(t *testing.T) {
+	sc := NewScanner(4, false)
+
+	// a false sync header in the garbage before the real frames
+	data := append([]byte{1, 2, 0xFF, 0xFB, 0x90, 0x64, 3, 4}, makeFrames(5)...)
+	offset, err := sc.Sync(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if offset != 8 {
+		t.Errorf("expected sync at offset 8, got %d", offset)
+	}
+
+	offset, err = sc.Sync(makeFrames(5)[:417*3+10])
+	if err != ErrNeedMoreData || offset != 0 {
+		t.Errorf("expected ErrNeedMoreData at 0, got %v at %d", err, offset)
+	}
+
+	_, err = sc.Sync(make([]byte, 2000))
+	if err != ErrNoSync {
+		t.Errorf("expected ErrNoSync, got %v", err)
+	}
+}
+
+func TestScannerParametersChange(t *testing.T) {
+	sc := NewScanner(3, false)
+	data := makeFrames(3)
+	// second frame claims 48000Hz
+	data[417+2] = 0x94
+	offset, err := sc.Sync(data)
+	if err == nil || offset == 0 {
+		t.Errorf("frames with changing sample rate should not sync, got %v at %d", err, offset)
+	}
+}
+
+func TestCRCValid(t *testing.T) {
+	frame := makeFrames(1)
+	// protected frame
+	frame[1] = 0xFA
+	for i := 6; i < 6+32; i++ {
+		frame[i] = byte(i)
+	}
+	crc := crc16(0xFFFF, frame[2:4])
+	crc = crc16(crc, frame[6:38])
+	frame[4] = byte(crc >> 8)
+	frame[5] = byte(crc)
+	if !CRCValid(frame) {
+		t.Error("frame with correct CRC considered invalid")
+	}
+	frame[10] ^= 0xFF
+	if CRCValid(frame) {
+		t.Error("frame with corrupted side info considered valid")
+	}
+}
@@ -0,0 +1,248 @@
+package mpegts
+
+import (
+	"errors"
+)
+
+type (
+	// Demuxed contains the audio elementary stream and timed metadata
+	// extracted from a transport stream
+	Demuxed struct {
+		AudioStreamType byte
+		Audio           []byte
+		Metadata        [][]byte
+	}
+
+	pesBuffer struct {
+		data    []byte
+		started bool
+	}
+)
+
+// PacketSize is the size of a transport stream packet
+const PacketSize = 188
+
+// SyncByte is the first byte of every transport stream packet
+const SyncByte = 0x47
+
+// Stream types as documented in ISO/IEC 13818-1
+const (
+	StreamTypeMPEG1Audio = 0x03
+	StreamTypeMPEG2Audio = 0x04
+	StreamTypeAAC        = 0x0F
+	StreamTypeMetadata   = 0x15
+)
+
+const (
+	pidPAT = 0x0000
+)
+
+// IsTransportStream returns true if data looks like a transport stream
+func IsTransportStream(data []byte) bool {
+	if len(data) < PacketSize || data[0] != SyncByte {
+		return false
+	}
+	if len(data) >= 2*PacketSize && data[PacketSize] != SyncByte {
+		return false
+	}
+	return true
+}
+
+// IsAudioStreamType returns true if the stream type is a supported audio stream
+func IsAudioStreamType(streamType byte) bool {
+	return streamType == StreamTypeMPEG1Audio ||
+		streamType == StreamTypeMPEG2Audio ||
+		streamType == StreamTypeAAC
+}
+
+// Demux extracts the first audio elementary stream and ID3 timed metadata
+// from a complete transport stream segment. PAT and PMT are expected to be
+// present in the segment as it's required for HLS
+func Demux(data []byte) (*Demuxed, error) {
+	result := &Demuxed{}
+	pmtPID := -1
+	audioPID := -1
+	metadataPIDs := make(map[int]*pesBuffer)
+	audio := &pesBuffer{}
+
+	for pos := 0; pos+PacketSize <= len(data); pos += PacketSize {
+		packet := data[pos : pos+PacketSize]
+		if packet[0] != SyncByte {
+			return nil, errors.New("transport stream sync lost")
+		}
+		unitStart := packet[1]&0x40 != 0
+		pid := int(packet[1]&0x1F)<<8 | int(packet[2])
+		adaptation := (packet[3] >> 4) & 0x03
+
+		payload := packet[4:]
+		if adaptation&0x02 != 0 {
+			afLength := int(payload[0])
+			if afLength+1 > len(payload) {
+				continue
+			}
+			payload = payload[afLength+1:]
+		}
+		if adaptation&0x01 == 0 || len(payload) == 0 {
+			continue
+		}
+
+		switch {
+		case pid == pidPAT:
+			if pmt := parsePAT(section(payload, unitStart)); pmt >= 0 {
+				pmtPID = pmt
+			}
+		case pid == pmtPID:
+			if audioPID >= 0 {
+				continue
+			}
+			for _, es := range parsePMT(section(payload, unitStart)) {
+				if IsAudioStreamType(es.streamType) && audioPID < 0 {
+					audioPID = es.pid
+					result.AudioStreamType = es.streamType
+				} else if es.streamType == StreamTypeMetadata {
+					metadataPIDs[es.pid] = &pesBuffer{}
+				}
+			}
+		case pid == audioPID:
+			result.Audio = audio.push(payload, unitStart, result.Audio)
+		default:
+			if md, found := metadataPIDs[pid]; found {
+				if unitStart && md.started {
+					result.Metadata = appendPESPayload(result.Metadata, md.data)
+					md.data = md.data[:0]
+				}
+				if unitStart {
+					md.started = true
+				}
+				if md.started {
+					md.data = append(md.data, payload...)
+				}
+			}
+		}
+	}
+
+	if audioPID < 0 {
+		return nil, errors.New("no supported audio stream found")
+	}
+	result.Audio = audio.flush(result.Audio)
+	for _, md := range metadataPIDs {
+		if md.started {
+			result.Metadata = appendPESPayload(result.Metadata, md.data)
+		}
+	}
+	return result, nil
+}
+
+// push adds the packet payload to the current PES packet and moves the
+// elementary stream data of complete PES packets to out
+func (pb *pesBuffer) push(payload []byte, unitStart bool, out []byte) []byte {
+	if unitStart {
+		out = pb.flush(out)
+		pb.started = true
+	}
+	if pb.started {
+		pb.data = append(pb.data, payload...)
+	}
+	return out
+}
+
+func (pb *pesBuffer) flush(out []byte) []byte {
+	if pb.started {
+		if es := pesPayload(pb.data); es != nil {
+			out = append(out, es...)
+		}
+	}
+	pb.data = pb.data[:0]
+	return out
+}
+
+func appendPESPayload(list [][]byte, pes []byte) [][]byte {
+	es := pesPayload(pes)
+	if len(es) == 0 {
+		return list
+	}
+	buf := make([]byte, len(es))
+	copy(buf, es)
+	return append(list, buf)
+}
+
+// pesPayload strips the PES header
+func pesPayload(pes []byte) []byte {
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return nil
+	}
+	streamID := pes[3]
+	// streams without the optional PES header
+	if streamID == 0xBE || streamID == 0xBF {
+		return pes[6:]
+	}
+	headerLength := 9 + int(pes[8])
+	if headerLength > len(pes) {
+		return nil
+	}
+	return pes[headerLength:]
+}
+
+// section returns the PSI section skipping the pointer field
+func section(payload []byte, unitStart bool) []byte {
+	if !unitStart {
+		return nil
+	}
+	pointer := int(payload[0])
+	if pointer+1 >= len(payload) {
+		return nil
+	}
+	return payload[pointer+1:]
+}
+
+func sectionBody(sec []byte, tableID byte) []byte {
+	if len(sec) < 3 || sec[0] != tableID {
+		return nil
+	}
+	length := int(sec[1]&0x0F)<<8 | int(sec[2])
+	// CRC32 is excluded
+	end := 3 + length - 4
+	if length < 9 || end > len(sec) {
+		return nil
+	}
+	return sec[3:end]
+}
+
+func parsePAT(sec []byte) int {
+	body := sectionBody(sec, 0x00)
+	if body == nil {
+		return -1
+	}
+	for pos := 5; pos+4 <= len(body); pos += 4 {
+		programNumber := int(body[pos])<<8 | int(body[pos+1])
+		pid := int(body[pos+2]&0x1F)<<8 | int(body[pos+3])
+		// program number 0 is the network PID
+		if programNumber != 0 {
+			return pid
+		}
+	}
+	return -1
+}
+
+type esInfo struct {
+	streamType byte
+	pid        int
+}
+
+func parsePMT(sec []byte) []esInfo {
+	body := sectionBody(sec, 0x02)
+	if len(body) < 9 {
+		return nil
+	}
+	programInfoLength := int(body[7]&0x0F)<<8 | int(body[8])
+	pos := 9 + programInfoLength
+	streams := make([]esInfo, 0)
+	for pos+5 <= len(body) {
+		streamType := body[pos]
+		pid := int(body[pos+1]&0x1F)<<8 | int(body[pos+2])
+		esInfoLength := int(body[pos+3]&0x0F)<<8 | int(body[pos+4])
+		streams = append(streams, esInfo{streamType, pid})
+		pos += 5 + esInfoLength
+	}
+	return streams
+}
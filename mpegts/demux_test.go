@@ -0,0 +1,86 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func makePacket(pid int, unitStart bool, payload []byte) []byte {
+	packet := make([]byte, PacketSize)
+	packet[0] = SyncByte
+	packet[1] = byte(pid>>8) & 0x1F
+	if unitStart {
+		packet[1] |= 0x40
+	}
+	packet[2] = byte(pid)
+	stuffing := PacketSize - 4 - len(payload)
+	if stuffing > 0 {
+		// adaptation field with stuffing bytes
+		packet[3] = 0x30
+		packet[4] = byte(stuffing - 1)
+		if stuffing > 1 {
+			packet[5] = 0
+			for i := 6; i < 4+stuffing; i++ {
+				packet[i] = 0xFF
+			}
+		}
+		copy(packet[4+stuffing:], payload)
+	} else {
+		packet[3] = 0x10
+		copy(packet[4:], payload)
+	}
+	return packet
+}
+
+func makeSection(tableID byte, body []byte) []byte {
+	length := len(body) + 4
+	sec := []byte{0, tableID, 0xB0 | byte(length>>8), byte(length)}
+	sec = append(sec, body...)
+	// CRC is not verified by the demuxer
+	return append(sec, 0, 0, 0, 0)
+}
+
+func makePES(streamID byte, payload []byte) []byte {
+	pes := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0, 0}
+	return append(pes, payload...)
+}
+
+func TestDemux(t *testing.T) {
+	var ts []byte
+	pat := makeSection(0x00, []byte{0, 1, 0xC1, 0, 0, 0, 1, 0xE1, 0x00})
+	ts = append(ts, makePacket(0, true, pat)...)
+	pmt := makeSection(0x02, []byte{
+		0, 1, 0xC1, 0, 0, 0xE1, 0x01, 0xF0, 0,
+		StreamTypeAAC, 0xE1, 0x01, 0xF0, 0,
+		StreamTypeMetadata, 0xE1, 0x02, 0xF0, 0,
+	})
+	ts = append(ts, makePacket(0x100, true, pmt)...)
+
+	audio1 := bytes.Repeat([]byte{1}, 300)
+	pes := makePES(0xC0, audio1)
+	ts = append(ts, makePacket(0x101, true, pes[:184])...)
+	ts = append(ts, makePacket(0x101, false, pes[184:])...)
+
+	id3 := []byte("ID3 tag payload")
+	ts = append(ts, makePacket(0x102, true, makePES(0xBD, id3))...)
+
+	audio2 := bytes.Repeat([]byte{2}, 50)
+	ts = append(ts, makePacket(0x101, true, makePES(0xC0, audio2))...)
+
+	if !IsTransportStream(ts) {
+		t.Fatal("transport stream is not detected")
+	}
+	demuxed, err := Demux(ts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if demuxed.AudioStreamType != StreamTypeAAC {
+		t.Errorf("unexpected audio stream type %d", demuxed.AudioStreamType)
+	}
+	if !bytes.Equal(demuxed.Audio, append(audio1, audio2...)) {
+		t.Errorf("audio stream is demuxed incorrectly, got %d bytes", len(demuxed.Audio))
+	}
+	if len(demuxed.Metadata) != 1 || !bytes.Equal(demuxed.Metadata[0], id3) {
+		t.Errorf("metadata is demuxed incorrectly: %v", demuxed.Metadata)
+	}
+}
@@ -0,0 +1,230 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	// packet prefix kept for codec detection
+	packetPrefixSize = 80
+)
+
+type (
+	// logicalStream tracks header packets of a single logical bitstream
+	logicalStream struct {
+		codec          string
+		headersLeft    int
+		packetsSeen    int
+		packet         []byte
+		headersDone    bool
+		flacLastHeader bool
+	}
+
+	// link is a single link of a (possibly chained) physical bitstream
+	link struct {
+		streams map[uint32]*logicalStream
+		headers []byte
+	}
+
+	// HeaderCache parses an Ogg physical bitstream fed via Write and keeps
+	// the codec header pages of the current chain link so that a listener
+	// joining in the middle of the stream may be given the headers first
+	HeaderCache struct {
+		sync.Mutex
+		carry     []byte
+		offset    uint64
+		pending   *link
+		inData    bool
+		headers   []byte
+		codecs    []string
+		dataStart uint64
+		ready     bool
+	}
+)
+
+// NewHeaderCache creates a new HeaderCache. offset is the stream position
+// of the first byte to be written, it's used to report data start positions
+func NewHeaderCache(offset uint64) *HeaderCache {
+	return &HeaderCache{offset: offset}
+}
+
+// Write feeds the cache with the next portion of the stream
+func (hc *HeaderCache) Write(data []byte) (int, error) {
+	hc.Lock()
+	defer hc.Unlock()
+
+	hc.carry = append(hc.carry, data...)
+	pos := 0
+	for pos < len(hc.carry) {
+		page, err := ReadPage(hc.carry[pos:])
+		if err != nil {
+			if IsShort(err) && len(hc.carry)-pos < MaxPageSize {
+				break
+			}
+			// lost sync, looking for the next capture pattern
+			next := bytes.Index(hc.carry[pos+1:], capturePattern)
+			if next < 0 {
+				// keeping a possible partial capture pattern
+				skip := len(hc.carry) - pos - len(capturePattern) + 1
+				if skip < 1 {
+					skip = 1
+				}
+				pos += skip
+				break
+			}
+			pos += next + 1
+			continue
+		}
+		hc.processPage(page, hc.offset+uint64(pos))
+		pos += len(page)
+	}
+
+	hc.offset += uint64(pos)
+	hc.carry = append(hc.carry[:0], hc.carry[pos:]...)
+	return len(data), nil
+}
+
+func (hc *HeaderCache) processPage(page Page, pagePos uint64) {
+	if page.BOS() {
+		if hc.pending == nil || hc.inData {
+			// a new chain link starts
+			hc.pending = &link{streams: make(map[uint32]*logicalStream)}
+			hc.inData = false
+		}
+		hc.pending.streams[page.Serial()] = &logicalStream{headersLeft: -1}
+	}
+
+	if hc.pending == nil || hc.inData {
+		return
+	}
+
+	ls, found := hc.pending.streams[page.Serial()]
+	if !found || ls.headersDone {
+		// first data page of the link, headers are considered complete
+		hc.completeLink(pagePos)
+		return
+	}
+
+	hc.pending.headers = append(hc.pending.headers, page...)
+	ls.consume(page)
+
+	for _, s := range hc.pending.streams {
+		if !s.headersDone {
+			return
+		}
+	}
+	hc.completeLink(pagePos + uint64(len(page)))
+}
+
+func (hc *HeaderCache) completeLink(dataStart uint64) {
+	hc.headers = hc.pending.headers
+	hc.codecs = hc.codecs[:0]
+	for _, s := range hc.pending.streams {
+		hc.codecs = append(hc.codecs, s.codec)
+	}
+	hc.dataStart = dataStart
+	hc.ready = true
+	hc.inData = true
+}
+
+// consume counts the header packets finished within the page
+func (ls *logicalStream) consume(page Page) {
+	body := page.Body()
+	pos := 0
+	for _, lacing := range page.Segments() {
+		if len(ls.packet) < packetPrefixSize {
+			end := pos + int(lacing)
+			if end-pos > packetPrefixSize-len(ls.packet) {
+				end = pos + packetPrefixSize - len(ls.packet)
+			}
+			ls.packet = append(ls.packet, body[pos:end]...)
+		}
+		pos += int(lacing)
+		if lacing < 255 {
+			ls.packetDone()
+			ls.packet = ls.packet[:0]
+			if ls.headersDone {
+				return
+			}
+		}
+	}
+}
+
+func (ls *logicalStream) packetDone() {
+	ls.packetsSeen++
+	if ls.packetsSeen == 1 {
+		ls.detectCodec()
+	} else if ls.codec == "flac" && len(ls.packet) > 0 {
+		ls.flacLastHeader = ls.packet[0]&0x80 != 0
+	}
+
+	if ls.codec == "flac" {
+		ls.headersDone = ls.flacLastHeader
+		return
+	}
+	ls.headersDone = ls.packetsSeen >= ls.headersLeft
+}
+
+// detectCodec sets up the number of header packets by the identification packet
+func (ls *logicalStream) detectCodec() {
+	p := ls.packet
+	switch {
+	case bytes.HasPrefix(p, []byte("\x01vorbis")):
+		ls.codec = "vorbis"
+		ls.headersLeft = 3
+	case bytes.HasPrefix(p, []byte("OpusHead")):
+		ls.codec = "opus"
+		ls.headersLeft = 2
+	case bytes.HasPrefix(p, []byte("\x7fFLAC")):
+		ls.codec = "flac"
+		// the mapping header contains STREAMINFO block
+		if len(p) > 13 {
+			ls.flacLastHeader = p[13]&0x80 != 0
+		}
+	case bytes.HasPrefix(p, []byte("Speex   ")):
+		ls.codec = "speex"
+		ls.headersLeft = 2
+		if len(p) >= 72 {
+			ls.headersLeft += int(binary.LittleEndian.Uint32(p[68:72]))
+		}
+	case bytes.HasPrefix(p, []byte("\x80theora")):
+		ls.codec = "theora"
+		ls.headersLeft = 3
+	default:
+		ls.codec = "unknown"
+		ls.headersLeft = 1
+	}
+}
+
+// Headers returns the header pages of the current chain link and the
+// stream position of the first data page following them. ok is false
+// if the headers have not been received completely yet
+func (hc *HeaderCache) Headers() (headers []byte, dataStart uint64, ok bool) {
+	hc.Lock()
+	defer hc.Unlock()
+	return hc.headers, hc.dataStart, hc.ready
+}
+
+// Codecs returns the list of codecs of the current chain link
+func (hc *HeaderCache) Codecs() []string {
+	hc.Lock()
+	defer hc.Unlock()
+	result := make([]string, len(hc.codecs))
+	copy(result, hc.codecs)
+	return result
+}
+
+// Sync returns the data starting with the first Ogg page header found
+func Sync(data []byte) ([]byte, bool) {
+	for i := 0; i+HeaderSize <= len(data); i++ {
+		if PageHeaderValid(data[i:]) {
+			_, err := ReadPage(data[i:])
+			if err == nil || IsShort(err) {
+				return data[i:], true
+			}
+		}
+	}
+	return data, false
+}
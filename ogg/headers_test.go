@@ -0,0 +1,115 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func makePage(flags byte, granule int64, serial uint32, seq uint32, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, packet := range packets {
+		size := len(packet)
+		for size >= 255 {
+			lacing = append(lacing, 255)
+			size -= 255
+		}
+		lacing = append(lacing, byte(size))
+		body = append(body, packet...)
+	}
+	page := make([]byte, HeaderSize, HeaderSize+len(lacing)+len(body))
+	copy(page, capturePattern)
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:18], serial)
+	binary.LittleEndian.PutUint32(page[18:22], seq)
+	page[26] = byte(len(lacing))
+	page = append(page, lacing...)
+	page = append(page, body...)
+	binary.LittleEndian.PutUint32(page[22:26], crc(page))
+	return page
+}
+
+func vorbisLink(serial uint32) (headers []byte, data []byte) {
+	headers = append(headers, makePage(FlagBOS, 0, serial, 0, []byte("\x01vorbis identification"))...)
+	headers = append(headers, makePage(0, 0, serial, 1, []byte("\x03vorbis comment"), bytes.Repeat([]byte{5}, 600))...)
+	data = append(data, makePage(0, 1024, serial, 2, bytes.Repeat([]byte{1}, 300))...)
+	data = append(data, makePage(FlagEOS, 2048, serial, 3, bytes.Repeat([]byte{2}, 300))...)
+	return
+}
+
+func TestReadPage(t *testing.T) {
+	page := makePage(FlagBOS, 0, 1, 0, []byte("OpusHead"))
+	p, err := ReadPage(page)
+	if err != nil {
+		t.Fatalf("error reading valid page: %s", err)
+	}
+	if !p.BOS() || p.Serial() != 1 || string(p.Body()) != "OpusHead" {
+		t.Error("page fields are parsed incorrectly")
+	}
+
+	_, err = ReadPage(page[:len(page)-1])
+	if !IsShort(err) {
+		t.Error("truncated page should be reported as short")
+	}
+
+	page[len(page)-1] ^= 0xFF
+	_, err = ReadPage(page)
+	if err == nil || IsShort(err) {
+		t.Error("page with invalid checksum should be rejected")
+	}
+}
+
+func TestHeaderCache(t *testing.T) {
+	headers, data := vorbisLink(10)
+	stream := append([]byte{}, bytes.Repeat([]byte{0xAA}, 100)...)
+	stream = append(stream, headers...)
+	stream = append(stream, data...)
+
+	hc := NewHeaderCache(1000)
+	// feeding in small portions to check page reassembly
+	for i := 0; i < len(stream); i += 7 {
+		end := i + 7
+		if end > len(stream) {
+			end = len(stream)
+		}
+		hc.Write(stream[i:end])
+	}
+
+	cached, dataStart, ok := hc.Headers()
+	if !ok {
+		t.Fatal("headers should be complete")
+	}
+	if !bytes.Equal(cached, headers) {
+		t.Error("cached headers differ from the stream headers")
+	}
+	if dataStart != uint64(1000+100+len(headers)) {
+		t.Errorf("unexpected data start %d", dataStart)
+	}
+	codecs := hc.Codecs()
+	if len(codecs) != 1 || codecs[0] != "vorbis" {
+		t.Errorf("unexpected codecs %v", codecs)
+	}
+
+	// chained stream
+	headers2, data2 := vorbisLink(20)
+	hc.Write(headers2)
+	cached, _, _ = hc.Headers()
+	if !bytes.Equal(cached, headers2) {
+		t.Error("headers should be replaced by the next chain link headers")
+	}
+	hc.Write(data2)
+	_, dataStart, _ = hc.Headers()
+	if dataStart != uint64(1000+len(stream)+len(headers2)) {
+		t.Errorf("unexpected data start %d for chained stream", dataStart)
+	}
+}
+
+func TestSync(t *testing.T) {
+	page := makePage(0, 100, 1, 5, []byte("data"))
+	data := append([]byte("garbageOggS"), page...)
+	synced, ok := Sync(data)
+	if !ok || !bytes.Equal(synced, page) {
+		t.Error("sync should find the page start")
+	}
+}
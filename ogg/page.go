@@ -0,0 +1,158 @@
+package ogg
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+type (
+	// Page is a single raw Ogg page including header and body
+	Page []byte
+)
+
+// Page header type flags as documented in RFC 3533
+const (
+	FlagContinued = 0x01
+	FlagBOS       = 0x02
+	FlagEOS       = 0x04
+)
+
+// HeaderSize is the size of the fixed part of the page header
+const HeaderSize = 27
+
+// MaxPageSize is the maximum possible size of an Ogg page
+const MaxPageSize = HeaderSize + 255 + 255*255
+
+var (
+	capturePattern = []byte("OggS")
+	crcTable       = makeCRCTable()
+
+	errShortPage = errors.New("not enough data for an ogg page")
+	errBadPage   = errors.New("invalid ogg page")
+)
+
+func makeCRCTable() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}
+
+func crc(data []byte) uint32 {
+	var c uint32
+	for i, b := range data {
+		// checksum field itself is considered to be zero
+		if i >= 22 && i < 26 {
+			b = 0
+		}
+		c = (c << 8) ^ crcTable[byte(c>>24)^b]
+	}
+	return c
+}
+
+// PageHeaderValid checks if data starts with something looking like an Ogg
+// page header. Only the fixed header part is checked, the page itself may be incomplete
+func PageHeaderValid(data []byte) bool {
+	if len(data) < HeaderSize {
+		return false
+	}
+	return data[0] == capturePattern[0] &&
+		data[1] == capturePattern[1] &&
+		data[2] == capturePattern[2] &&
+		data[3] == capturePattern[3] &&
+		data[4] == 0 &&
+		data[5]&^(FlagContinued|FlagBOS|FlagEOS) == 0
+}
+
+// ReadPage tries to read a complete page from the beginning of data.
+// It returns errShortPage-like error if data is not long enough
+// and a different error if the page is invalid or its checksum mismatches
+func ReadPage(data []byte) (Page, error) {
+	if len(data) < HeaderSize {
+		return nil, errShortPage
+	}
+	if !PageHeaderValid(data) {
+		return nil, errBadPage
+	}
+	segments := int(data[26])
+	if len(data) < HeaderSize+segments {
+		return nil, errShortPage
+	}
+	size := HeaderSize + segments
+	for _, lacing := range data[HeaderSize : HeaderSize+segments] {
+		size += int(lacing)
+	}
+	if len(data) < size {
+		return nil, errShortPage
+	}
+	page := Page(data[:size])
+	if crc(page) != page.Checksum() {
+		return nil, errors.New("ogg page checksum mismatch")
+	}
+	return page, nil
+}
+
+// IsShort returns true if the error returned by ReadPage means that
+// more data is needed to read the page
+func IsShort(err error) bool {
+	return err == errShortPage
+}
+
+// HeaderType returns the header type flags of the page
+func (p Page) HeaderType() byte {
+	return p[5]
+}
+
+// BOS returns true if the page is the first page of a logical bitstream
+func (p Page) BOS() bool {
+	return p[5]&FlagBOS != 0
+}
+
+// EOS returns true if the page is the last page of a logical bitstream
+func (p Page) EOS() bool {
+	return p[5]&FlagEOS != 0
+}
+
+// Continued returns true if the page starts with a continuation of a packet
+func (p Page) Continued() bool {
+	return p[5]&FlagContinued != 0
+}
+
+// GranulePosition returns the codec specific position of the page
+func (p Page) GranulePosition() int64 {
+	return int64(binary.LittleEndian.Uint64(p[6:14]))
+}
+
+// Serial returns the logical bitstream serial number
+func (p Page) Serial() uint32 {
+	return binary.LittleEndian.Uint32(p[14:18])
+}
+
+// Sequence returns the page sequence number
+func (p Page) Sequence() uint32 {
+	return binary.LittleEndian.Uint32(p[18:22])
+}
+
+// Checksum returns the CRC checksum stored in the page header
+func (p Page) Checksum() uint32 {
+	return binary.LittleEndian.Uint32(p[22:26])
+}
+
+// Segments returns the lacing values of the page
+func (p Page) Segments() []byte {
+	return p[HeaderSize : HeaderSize+int(p[26])]
+}
+
+// Body returns the page payload
+func (p Page) Body() []byte {
+	return p[HeaderSize+int(p[26]):]
+}
@@ -0,0 +1,178 @@
+package playlist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Format is a playlist format
+	Format int
+
+	xspfPlaylist struct {
+		Tracks []struct {
+			Locations []string `xml:"location"`
+		} `xml:"trackList>track"`
+	}
+
+	plsEntry struct {
+		index int
+		url   string
+	}
+)
+
+// Format valid values
+const (
+	FormatNone Format = iota
+	FormatM3U
+	FormatPLS
+	FormatXSPF
+)
+
+var (
+	contentTypes = map[string]Format{
+		"audio/x-mpegurl":               FormatM3U,
+		"audio/mpegurl":                 FormatM3U,
+		"application/x-mpegurl":         FormatM3U,
+		"application/vnd.apple.mpegurl": FormatM3U,
+		"audio/x-scpls":                 FormatPLS,
+		"audio/scpls":                   FormatPLS,
+		"application/pls+xml":           FormatPLS,
+		"application/xspf+xml":          FormatXSPF,
+	}
+
+	extensions = map[string]Format{
+		".m3u":  FormatM3U,
+		".m3u8": FormatM3U,
+		".pls":  FormatPLS,
+		".xspf": FormatXSPF,
+	}
+
+	// ErrHLS is returned when an M3U playlist is actually an HLS playlist
+	ErrHLS = errors.New("playlist is an HLS playlist")
+	// ErrEmpty is returned when a playlist contains no entries
+	ErrEmpty = errors.New("playlist is empty")
+)
+
+// Detect returns the playlist format by the response content type
+// or (if the content type is not specific) by the URL path extension
+func Detect(contentType string, urlPath string) Format {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		if format, found := contentTypes[strings.ToLower(mediaType)]; found {
+			return format
+		}
+		// stream content types should not be treated as playlists
+		// regardless of the extension
+		if strings.HasPrefix(mediaType, "audio/") || mediaType == "application/ogg" {
+			return FormatNone
+		}
+	}
+	return extensions[strings.ToLower(path.Ext(urlPath))]
+}
+
+// Parse returns playlist entries in the order of appearance
+func Parse(format Format, data []byte) ([]string, error) {
+	var entries []string
+	var err error
+	switch format {
+	case FormatM3U:
+		entries, err = parseM3U(data)
+	case FormatPLS:
+		entries, err = parsePLS(data)
+	case FormatXSPF:
+		entries, err = parseXSPF(data)
+	default:
+		return nil, errors.New("unknown playlist format")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrEmpty
+	}
+	return entries, nil
+}
+
+// IsHLS returns true if the M3U data is an HLS playlist
+func IsHLS(data []byte) bool {
+	return bytes.Contains(data, []byte("#EXT-X-TARGETDURATION")) ||
+		bytes.Contains(data, []byte("#EXT-X-STREAM-INF")) ||
+		bytes.Contains(data, []byte("#EXT-X-MEDIA-SEQUENCE"))
+}
+
+func parseM3U(data []byte) ([]string, error) {
+	if IsHLS(data) {
+		return nil, ErrHLS
+	}
+	entries := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+func parsePLS(data []byte) ([]string, error) {
+	plsEntries := make([]plsEntry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if !strings.HasPrefix(key, "file") {
+			continue
+		}
+		index, err := strconv.Atoi(key[4:])
+		if err != nil {
+			continue
+		}
+		plsEntries = append(plsEntries, plsEntry{index, strings.TrimSpace(kv[1])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(plsEntries, func(i, j int) bool {
+		return plsEntries[i].index < plsEntries[j].index
+	})
+	entries := make([]string, 0, len(plsEntries))
+	for _, entry := range plsEntries {
+		if entry.url != "" {
+			entries = append(entries, entry.url)
+		}
+	}
+	return entries, nil
+}
+
+func parseXSPF(data []byte) ([]string, error) {
+	var pl xspfPlaylist
+	err := xml.Unmarshal(data, &pl)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]string, 0, len(pl.Tracks))
+	for _, track := range pl.Tracks {
+		for _, location := range track.Locations {
+			location = strings.TrimSpace(location)
+			if location != "" {
+				entries = append(entries, location)
+			}
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,71 @@
+package playlist
+
+import (
+	"testing"
+)
+
+func assertEntries(t *testing.T, entries []string, expected []string) {
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %v", len(expected), len(entries), entries)
+	}
+	for i, entry := range entries {
+		if entry != expected[i] {
+			t.Errorf("entry %d: expected %s, got %s", i, expected[i], entry)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	if Detect("audio/x-scpls", "/listen") != FormatPLS {
+		t.Error("pls content type is not detected")
+	}
+	if Detect("text/plain", "/listen.m3u") != FormatM3U {
+		t.Error("m3u extension is not detected")
+	}
+	if Detect("audio/mpeg", "/stream.m3u") != FormatNone {
+		t.Error("audio stream should not be detected as a playlist")
+	}
+}
+
+func TestParseM3U(t *testing.T) {
+	data := []byte("#EXTM3U\r\n#EXTINF:-1,Station\r\nhttp://a.example.com/stream\r\n\r\nhttp://b.example.com/stream\r\n")
+	entries, err := Parse(FormatM3U, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertEntries(t, entries, []string{"http://a.example.com/stream", "http://b.example.com/stream"})
+
+	_, err = Parse(FormatM3U, []byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\nseg1.ts\n"))
+	if err != ErrHLS {
+		t.Errorf("expected ErrHLS, got %v", err)
+	}
+}
+
+func TestParsePLS(t *testing.T) {
+	data := []byte("[playlist]\nNumberOfEntries=2\nFile2=http://b.example.com/\nTitle1=A\nFile1=http://a.example.com/\nVersion=2\n")
+	entries, err := Parse(FormatPLS, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertEntries(t, entries, []string{"http://a.example.com/", "http://b.example.com/"})
+}
+
+func TestParseXSPF(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track><location>http://a.example.com/stream</location><title>A</title></track>
+    <track><location> http://b.example.com/stream </location></track>
+  </trackList>
+</playlist>`)
+	entries, err := Parse(FormatXSPF, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertEntries(t, entries, []string{"http://a.example.com/stream", "http://b.example.com/stream"})
+
+	_, err = Parse(FormatXSPF, []byte(`<playlist><trackList></trackList></playlist>`))
+	if err != ErrEmpty {
+		t.Errorf("expected ErrEmpty, got %v", err)
+	}
+}